@@ -0,0 +1,153 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/relay"
+)
+
+func NewConnectConnectCmd(logger logger.Logger) *cobra.Command {
+	var srcNetwork string
+	var srcAddress string
+	var rendezvousAddress string
+	var idleTunnels int
+	var tunnelHandshake string
+	var bufferSize int
+	var healthCheckInterval time.Duration
+	var flags relayFlags
+	var packetFlags seqPacketFlags
+
+	cmdInstance := &cobra.Command{
+		Use:   "connect-connect",
+		Short: "relay from a unix or TCP source to tunnels dialed to a rendezvous TCP endpoint",
+		Long: `relay from a unix or TCP source to tunnels dialed to a rendezvous TCP endpoint.
+
+Both ends are dialed, which works for hosts that can only make outbound connections.
+A number of idle tunnels is kept open to the rendezvous endpoint.
+Once the rendezvous side sends the first bytes over a tunnel, or ` + "`--tunnel-handshake`" + ` if set,
+the source is dialed and a new idle tunnel replaces the used one.`,
+		RunE: func(command *cobra.Command, args []string) error {
+			opts, err := flags.options(logger)
+			// NOTE: Also closes what `options` opened before failing.
+			defer flags.close()
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid relay flags specified")
+			}
+
+			opts = append(opts, packetFlags.options()...)
+			if tunnelHandshake != "" {
+				opts = append(opts, relay.WithTunnelHandshake([]byte(tunnelHandshake)))
+			}
+
+			relayer, err := relay.NewConnectConnect(
+				logger,
+				healthCheckInterval,
+				srcNetwork,
+				srcAddress,
+				rendezvousAddress,
+				idleTunnels,
+				bufferSize,
+				opts...,
+			)
+			if err != nil {
+				return stacktrace.Propagate(err, "couldn't create relay from source to rendezvous")
+			}
+
+			osSignalCh := make(chan os.Signal, 1)
+			defer close(osSignalCh)
+
+			signal.Notify(osSignalCh, os.Interrupt, syscall.SIGTERM)
+
+			ctx, cancelFunc := context.WithCancel(context.Background())
+			defer cancelFunc()
+
+			err = flags.start(ctx, logger, relayer)
+			if err != nil {
+				return stacktrace.Propagate(err, "couldn't start relay services")
+			}
+
+			// Ctrl+C handler
+			go func() {
+				<-osSignalCh
+				signal.Stop(osSignalCh)
+				cancelFunc()
+			}()
+
+			err = relayer.Relay(ctx)
+			return stacktrace.Propagate(err, "couldn't relay from source to rendezvous")
+		},
+	}
+
+	cmdInstance.Flags().DurationVar(
+		&healthCheckInterval,
+		"health-check-interval",
+		30*time.Second,
+		"health check interval for `src`, e.g values are 30m, 60s, 1h.",
+	)
+	cmdInstance.Flags().StringVar(
+		&srcNetwork,
+		"src-network",
+		"unix",
+		"network of `src`, either unix or tcp",
+	)
+	cmdInstance.Flags().StringVar(
+		&srcAddress,
+		"src",
+		"",
+		"source unix domain socket path or TCP <addr>:<port>",
+	)
+	_ = cmdInstance.MarkFlagRequired("src")
+	cmdInstance.Flags().StringVar(
+		&rendezvousAddress,
+		"dst",
+		"",
+		"rendezvous TCP <addr>:<port> to dial tunnels to",
+	)
+	_ = cmdInstance.MarkFlagRequired("dst")
+	cmdInstance.Flags().IntVar(
+		&idleTunnels,
+		"idle-tunnels",
+		1,
+		"number of idle tunnels kept open to `dst`",
+	)
+	cmdInstance.Flags().StringVar(
+		&tunnelHandshake,
+		"tunnel-handshake",
+		"",
+		"bytes the rendezvous side sends to claim a tunnel, not relayed. "+
+			"Without it, tunnels are claimed by the first client bytes, "+
+			"so protocols where the server speaks first (SMTP, MySQL) hang.",
+	)
+	cmdInstance.Flags().IntVar(
+		&bufferSize,
+		"buffer-size",
+		DefaultBufferSize,
+		"Buffer size in bytes of the data stream",
+	)
+	flags.register(cmdInstance)
+	packetFlags.register(cmdInstance, "src")
+
+	return cmdInstance
+}
@@ -0,0 +1,315 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+	"github.com/sumup-oss/go-pkgs/os"
+
+	"github.com/sumup-oss/gocat/internal/admin"
+)
+
+// ctlFlags are shared by the `ctl` subcommands.
+type ctlFlags struct {
+	address   string
+	tokenFile string
+	timeout   time.Duration
+	relayName string
+}
+
+func (f *ctlFlags) register(cmdInstance *cobra.Command) {
+	cmdInstance.PersistentFlags().StringVar(
+		&f.address,
+		"admin",
+		"",
+		"admin API of the running gocat, either its `--admin-socket` path or an http:// URL of its `--admin-listen`",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.tokenFile,
+		"token-file",
+		"",
+		"file with the bearer token of the admin API, its `--admin-token-file`",
+	)
+	cmdInstance.PersistentFlags().DurationVar(
+		&f.timeout,
+		"timeout",
+		time.Minute,
+		"timeout of admin API requests",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.relayName,
+		"relay",
+		"",
+		"name of the relay to control. Defaults to the only relay.",
+	)
+}
+
+func (f *ctlFlags) client() (*admin.Client, error) {
+	var token string
+	if f.tokenFile != "" {
+		var err error
+		token, err = readTokenFile(f.tokenFile)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `token-file` specified")
+		}
+	}
+
+	client, err := admin.NewClient(f.address, token, f.timeout)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid `admin` specified")
+	}
+
+	return client, nil
+}
+
+// relay returns the name of the relay to control, looking it up if not specified.
+func (f *ctlFlags) relay(ctx context.Context, client *admin.Client) (string, error) {
+	if f.relayName != "" {
+		return f.relayName, nil
+	}
+
+	relays, err := client.Relays(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(relays) != 1 {
+		names := make([]string, 0, len(relays))
+		for _, relay := range relays {
+			names = append(names, relay.Name)
+		}
+
+		return "", stacktrace.NewError("`relay` not specified, expected one of %s", strings.Join(names, ", "))
+	}
+
+	return relays[0].Name, nil
+}
+
+func NewCtlCmd(osExecutor os.OsExecutor) *cobra.Command {
+	var flags ctlFlags
+
+	cmdInstance := &cobra.Command{
+		Use:   "ctl",
+		Short: "control a running gocat",
+		Long: `control a running gocat through the admin API it serves at ` + "`--admin-socket`" + ` or ` +
+			"`--admin-listen`" + `,
+e.g list its connections, kill one of them or pause accepting new ones.`,
+		RunE: func(command *cobra.Command, args []string) error {
+			return command.Help()
+		},
+	}
+
+	flags.register(cmdInstance)
+
+	cmdInstance.AddCommand(
+		&cobra.Command{
+			Use:   "relays",
+			Short: "list relays",
+			Args:  cobra.NoArgs,
+			RunE: func(command *cobra.Command, args []string) error {
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				relays, err := client.Relays(context.Background())
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(osExecutor.Stdout(), 0, 4, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "NAME\tSOURCE\tDESTINATION\tPAUSED\tACTIVE\tACCEPTED")
+				for _, relay := range relays {
+					_, _ = fmt.Fprintf(
+						w,
+						"%s\t%s\t%s %s\t%t\t%d\t%d\n",
+						relay.Name,
+						relay.Source,
+						relay.Destination,
+						relay.DestinationAddress,
+						relay.Paused,
+						relay.ActiveConnections,
+						relay.AcceptedConnections,
+					)
+				}
+
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "connections",
+			Short: "list the connections being relayed",
+			Args:  cobra.NoArgs,
+			RunE: func(command *cobra.Command, args []string) error {
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				relayName, err := flags.relay(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				connections, err := client.Connections(ctx, relayName)
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(osExecutor.Stdout(), 0, 4, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "ID\tCLIENT\tSOURCE\tAGE\tBYTES IN\tBYTES OUT")
+				for _, connection := range connections {
+					age := time.Duration(connection.AgeSeconds * float64(time.Second))
+					_, _ = fmt.Fprintf(
+						w,
+						"%d\t%s\t%s\t%s\t%d\t%d\n",
+						connection.ID,
+						connection.Client,
+						connection.Source,
+						age.Round(time.Millisecond),
+						connection.BytesIn,
+						connection.BytesOut,
+					)
+				}
+
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "kill <connection ID>",
+			Short: "close a connection",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(command *cobra.Command, args []string) error {
+				id, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return stacktrace.Propagate(err, "invalid connection ID %s", args[0])
+				}
+
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				relayName, err := flags.relay(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				err = client.KillConnection(ctx, relayName, id)
+				if err != nil {
+					return err
+				}
+
+				_, _ = fmt.Fprintf(osExecutor.Stdout(), "Killed connection %d\n", id)
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "pause",
+			Short: "stop accepting new connections, leaving them in the listen backlog",
+			Args:  cobra.NoArgs,
+			RunE: func(command *cobra.Command, args []string) error {
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				relayName, err := flags.relay(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				_, err = client.Pause(ctx, relayName)
+				if err != nil {
+					return err
+				}
+
+				_, _ = fmt.Fprintf(osExecutor.Stdout(), "Paused %s\n", relayName)
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "resume",
+			Short: "resume accepting new connections",
+			Args:  cobra.NoArgs,
+			RunE: func(command *cobra.Command, args []string) error {
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				relayName, err := flags.relay(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				_, err = client.Resume(ctx, relayName)
+				if err != nil {
+					return err
+				}
+
+				_, _ = fmt.Fprintf(osExecutor.Stdout(), "Resumed %s\n", relayName)
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "health-check",
+			Short: "health check the source now",
+			Args:  cobra.NoArgs,
+			RunE: func(command *cobra.Command, args []string) error {
+				client, err := flags.client()
+				if err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				relayName, err := flags.relay(ctx, client)
+				if err != nil {
+					return err
+				}
+
+				result, err := client.CheckHealth(ctx, relayName)
+				if err != nil {
+					return err
+				}
+
+				if !result.Healthy {
+					return stacktrace.NewError("%s is unhealthy: %s", relayName, result.Error)
+				}
+
+				_, _ = fmt.Fprintf(osExecutor.Stdout(), "%s is healthy\n", relayName)
+
+				return nil
+			},
+		},
+	)
+
+	return cmdInstance
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+)
+
+// logFlags override the logging configured by environment variables.
+type logFlags struct {
+	level          string
+	format         string
+	sink           string
+	syslogAddress  string
+	syslogFacility string
+	syslogCAFile   string
+	journaldSocket string
+}
+
+func (f *logFlags) register(cmdInstance *cobra.Command) {
+	cmdInstance.PersistentFlags().StringVar(
+		&f.level,
+		"log-level",
+		"",
+		"log level, one of debug, info, warn, error, fatal or panic. Overrides `LOG_LEVEL` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.format,
+		"log-format",
+		"",
+		"log format of the stderr sink, one of text, json or logfmt. Overrides `LOG_FORMAT` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.sink,
+		"log-sink",
+		"",
+		"where to write logs, one of stderr, syslog or journald. Overrides `LOG_SINK` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.syslogAddress,
+		"syslog-address",
+		"",
+		"syslog address, e.g unix:///dev/log, udp://host:514, tcp://host:601 or tls://host:6514. "+
+			"Overrides `SYSLOG_ADDRESS` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.syslogFacility,
+		"syslog-facility",
+		"",
+		"syslog facility, e.g daemon or local0. Overrides `SYSLOG_FACILITY` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.syslogCAFile,
+		"syslog-ca-file",
+		"",
+		"PEM CA certificates verifying a tls:// syslog server instead of the system roots. "+
+			"Overrides `SYSLOG_CA_FILE` if set.",
+	)
+	cmdInstance.PersistentFlags().StringVar(
+		&f.journaldSocket,
+		"journald-socket",
+		"",
+		"journald native protocol socket. Overrides `JOURNALD_SOCKET` if set.",
+	)
+}
+
+// apply overrides the level and sink of `logger`, configured by `sinkConfig`, with the flags set.
+func (f *logFlags) apply(logger *logging.Logger, sinkConfig logging.SinkConfig) error {
+	if f.level != "" {
+		level, err := logging.ParseLevel(f.level)
+		if err != nil {
+			return stacktrace.Propagate(err, "invalid `log-level` specified")
+		}
+
+		logger.SetLevel(level)
+	}
+
+	overrides := []struct {
+		value  string
+		target *string
+	}{
+		{f.sink, &sinkConfig.Sink},
+		{f.syslogAddress, &sinkConfig.SyslogAddress},
+		{f.syslogFacility, &sinkConfig.SyslogFacility},
+		{f.syslogCAFile, &sinkConfig.SyslogCAFile},
+		{f.journaldSocket, &sinkConfig.JournaldSocket},
+	}
+
+	changed := false
+	for _, override := range overrides {
+		if override.value != "" {
+			*override.target = override.value
+			changed = true
+		}
+	}
+
+	if f.format != "" {
+		format, err := logging.ParseFormat(f.format)
+		if err != nil {
+			return stacktrace.Propagate(err, "invalid `log-format` specified")
+		}
+
+		sinkConfig.Format = format
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	sink, err := logging.NewSink(sinkConfig)
+	if err != nil {
+		return stacktrace.Propagate(err, "invalid log sink flags specified")
+	}
+
+	logger.SetSink(sink)
+
+	return nil
+}
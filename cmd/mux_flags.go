@@ -0,0 +1,119 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+
+	"github.com/sumup-oss/gocat/internal/relay"
+)
+
+// muxFlags configure the single (optionally TLS) mux session
+// shared by a `unix-to-tcp` and a `tcp-to-unix` gocat instance.
+type muxFlags struct {
+	enabled       bool
+	tls           bool
+	tlsCert       string
+	tlsKey        string
+	tlsCA         string
+	tlsServerName string
+}
+
+func (f *muxFlags) register(cmdInstance *cobra.Command) {
+	cmdInstance.Flags().BoolVar(
+		&f.enabled,
+		"mux",
+		false,
+		"multiplex all connections over a single TCP connection to/from a paired gocat instance also using `--mux`",
+	)
+	cmdInstance.Flags().BoolVar(&f.tls, "mux-tls", false, "secure the mux TCP connection with TLS")
+	cmdInstance.Flags().StringVar(
+		&f.tlsCert,
+		"mux-tls-cert",
+		"",
+		"path to PEM certificate presented on the mux TLS connection. Required for the listening side.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.tlsKey,
+		"mux-tls-key",
+		"",
+		"path to PEM private key of `mux-tls-cert`",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.tlsCA,
+		"mux-tls-ca",
+		"",
+		"path to PEM CA certificates verifying the peer. "+
+			"Makes the listening side require client certificates. The dialing side defaults to the system CAs.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.tlsServerName,
+		"mux-tls-server-name",
+		"",
+		"server name verified by the dialing side. Defaults to the host of `src`.",
+	)
+}
+
+func (f *muxFlags) options(listening bool) ([]relay.Option, error) {
+	if !f.enabled {
+		return nil, nil
+	}
+
+	if !f.tls {
+		return []relay.Option{relay.WithMultiplexing(nil)}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: f.tlsServerName,
+	}
+
+	if f.tlsCert != "" || f.tlsKey != "" {
+		certificate, err := tls.LoadX509KeyPair(f.tlsCert, f.tlsKey)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed to load `mux-tls-cert` and `mux-tls-key`")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	} else if listening {
+		return nil, stacktrace.NewError("`mux-tls-cert` and `mux-tls-key` are required to accept mux TLS connections")
+	}
+
+	if f.tlsCA != "" {
+		caPEM, err := ioutil.ReadFile(f.tlsCA)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed to read `mux-tls-ca` %s", f.tlsCA)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, stacktrace.NewError("no PEM certificates found in `mux-tls-ca` %s", f.tlsCA)
+		}
+
+		if listening {
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	return []relay.Option{relay.WithMultiplexing(tlsConfig)}, nil
+}
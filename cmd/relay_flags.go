@@ -0,0 +1,1002 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/admin"
+	"github.com/sumup-oss/gocat/internal/logfile"
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/metrics"
+	"github.com/sumup-oss/gocat/internal/relay"
+	"github.com/sumup-oss/gocat/internal/tracing"
+)
+
+// relayFlags are the flags shared by all relay commands.
+type relayFlags struct {
+	name          string
+	metricsListen string
+
+	breakerConsecutiveFailures int
+	breakerFailureRatio        float64
+	breakerRatioWindow         int
+	breakerCooldown            time.Duration
+
+	sourcePoolSize    int
+	sourcePoolMaxIdle time.Duration
+
+	allowCIDRs  []string
+	denyCIDRs   []string
+	ipRulesFile string
+
+	maxConnections          int
+	maxConnectionsPerClient int
+	connectionQueueTimeout  time.Duration
+
+	rateLimit         float64
+	rateLimitBurst    int
+	clientRateLimit   float64
+	clientRateBurst   int
+	clientBanAfter    int
+	clientBanDuration time.Duration
+
+	uploadLimit             string
+	downloadLimit           string
+	connectionUploadLimit   string
+	connectionDownloadLimit string
+	bandwidthFile           string
+
+	firstByteTimeout   time.Duration
+	deferDial          bool
+	handshakeDelimiter string
+	handshakeMaxSize   int
+
+	dump              bool
+	dumpFile          string
+	dumpMaxChunkBytes int
+	dumpMaxConnBytes  int
+	dumpConnections   []uint
+	dumpClients       []string
+
+	capturePrefix        string
+	capturePerConnection bool
+	captureMaxSize       string
+	captureMaxAge        time.Duration
+
+	recordFile string
+
+	relayLogLevel string
+
+	accessLogPath     string
+	accessLogTemplate string
+	accessLogMaxSize  string
+	accessLogMaxAge   time.Duration
+	accessLogCompress bool
+
+	otlpEndpoint      string
+	otlpHeaders       []string
+	otlpServiceName   string
+	otlpFlushInterval time.Duration
+
+	statsDAddress       string
+	statsDPrefix        string
+	statsDFlavor        string
+	statsDFlushInterval time.Duration
+
+	adminSocket    string
+	adminListen    string
+	adminTokenFile string
+
+	metricsRegistry *metrics.Registry
+	ipFilter        *relay.IPFilter
+	shaper          *relay.BandwidthShaper
+	dumpFileFd      *os.File
+	capturer        *relay.Capturer
+	sessionRecorder *relay.SessionRecorder
+	accessLogFile   *logfile.File
+	tracer          *tracing.Exporter
+	statsD          *metrics.StatsD
+	adminListeners  []net.Listener
+}
+
+func (f *relayFlags) register(cmdInstance *cobra.Command) {
+	cmdInstance.Flags().StringVar(
+		&f.name,
+		"name",
+		cmdInstance.Name(),
+		"name of the relay used in logs and metrics",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.metricsListen,
+		"metrics-listen",
+		"",
+		"TCP address to serve Prometheus metrics at `/metrics`, e.g 127.0.0.1:9100. Disabled if empty.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.breakerConsecutiveFailures,
+		"circuit-breaker-failures",
+		0,
+		"open the circuit breaker of `src` dials after this many consecutive failures. Disabled if 0.",
+	)
+	cmdInstance.Flags().Float64Var(
+		&f.breakerFailureRatio,
+		"circuit-breaker-failure-ratio",
+		0,
+		"open the circuit breaker of `src` dials when this ratio (0-1] of the last "+
+			"`circuit-breaker-window` dials failed. Disabled if 0.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.breakerRatioWindow,
+		"circuit-breaker-window",
+		20,
+		"number of last `src` dials the circuit breaker failure ratio is calculated over",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.breakerCooldown,
+		"circuit-breaker-cooldown",
+		10*time.Second,
+		"how long the circuit breaker stays open before probing `src` again",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.sourcePoolSize,
+		"src-pool-size",
+		0,
+		"number of pre-dialed `src` connections kept open for accepted connections. Disabled if 0.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.sourcePoolMaxIdle,
+		"src-pool-max-idle",
+		5*time.Minute,
+		"discard pre-dialed `src` connections idle for longer than this. Never discarded if 0.",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&f.allowCIDRs,
+		"allow-cidr",
+		nil,
+		"only accept TCP clients from these comma-separated CIDRs or IPs. Accepts all if empty.",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&f.denyCIDRs,
+		"deny-cidr",
+		nil,
+		"reject TCP clients from these comma-separated CIDRs or IPs. Takes precedence over `allow-cidr`.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.ipRulesFile,
+		"ip-rules-file",
+		"",
+		"file with `allow <CIDR>` and `deny <CIDR>` lines, in addition to `allow-cidr` and `deny-cidr`. "+
+			"Reloaded on SIGHUP.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.maxConnections,
+		"max-connections",
+		0,
+		"maximum concurrently relayed connections. Unlimited if 0.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.maxConnectionsPerClient,
+		"max-connections-per-client",
+		0,
+		"maximum concurrently relayed connections per client IP, or per UID of unix socket clients. Unlimited if 0.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.connectionQueueTimeout,
+		"connection-queue-timeout",
+		0,
+		"how long connections over `max-connections` or `max-connections-per-client` wait for a free slot "+
+			"before being rejected. Rejected right away if 0.",
+	)
+	cmdInstance.Flags().Float64Var(
+		&f.rateLimit,
+		"rate-limit",
+		0,
+		"maximum new connections per second across all clients. Unlimited if 0.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.rateLimitBurst,
+		"rate-limit-burst",
+		1,
+		"new connections accepted at once over `rate-limit`",
+	)
+	cmdInstance.Flags().Float64Var(
+		&f.clientRateLimit,
+		"client-rate-limit",
+		0,
+		"maximum new connections per second per client IP, or per UID of unix socket clients. Unlimited if 0.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.clientRateBurst,
+		"client-rate-limit-burst",
+		1,
+		"new connections accepted at once per client over `client-rate-limit`",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.clientBanAfter,
+		"client-ban-after",
+		0,
+		"ban clients exceeding `client-rate-limit` this many times, without slowing down in between, "+
+			"for `client-ban-duration`. Disabled if 0.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.clientBanDuration,
+		"client-ban-duration",
+		10*time.Minute,
+		"how long clients banned by `client-ban-after` are rejected",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.uploadLimit,
+		"upload-limit",
+		"",
+		"maximum bytes per second sent by all clients to `src`, with an optional K, M or G suffix, e.g 10M. "+
+			"Unlimited if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.downloadLimit,
+		"download-limit",
+		"",
+		"maximum bytes per second sent by `src` to all clients, e.g 10M. Unlimited if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.connectionUploadLimit,
+		"conn-upload-limit",
+		"",
+		"maximum bytes per second sent by each client to `src`, e.g 512K. Unlimited if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.connectionDownloadLimit,
+		"conn-download-limit",
+		"",
+		"maximum bytes per second sent by `src` to each client, e.g 512K. Unlimited if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.bandwidthFile,
+		"bandwidth-file",
+		"",
+		"file with `upload`, `download`, `connection-upload` and `connection-download` <rate> lines, "+
+			"overriding the bandwidth limit flags. Reloaded on SIGHUP.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.firstByteTimeout,
+		"first-byte-timeout",
+		0,
+		"close client connections sending nothing within this long after being accepted. Disabled if 0.",
+	)
+	cmdInstance.Flags().BoolVar(
+		&f.deferDial,
+		"defer-dial",
+		false,
+		"dial `src` only once the client sent its first bytes. Unsuitable for protocols where the server speaks first.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.handshakeDelimiter,
+		"handshake-delimiter",
+		"",
+		"dial `src` only once the client sent data containing this Go-escaped delimiter, e.g \\r\\n, "+
+			"within `first-byte-timeout`",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.handshakeMaxSize,
+		"handshake-max-size",
+		4096,
+		"close client connections sending more than this many bytes before `handshake-delimiter`",
+	)
+	cmdInstance.Flags().BoolVar(
+		&f.dump,
+		"dump",
+		false,
+		"dump relayed data in hex and ASCII with its direction, connection ID and timestamp, similar to `socat -v -x`",
+	)
+	cmdInstance.Flags().StringVar(&f.dumpFile, "dump-file", "", "file the dump is appended to. Defaults to stderr.")
+	cmdInstance.Flags().IntVar(
+		&f.dumpMaxChunkBytes,
+		"dump-max-chunk-bytes",
+		0,
+		"maximum dumped bytes of each relayed chunk. Unlimited if 0.",
+	)
+	cmdInstance.Flags().IntVar(
+		&f.dumpMaxConnBytes,
+		"dump-max-conn-bytes",
+		0,
+		"maximum dumped bytes of each connection and direction. Unlimited if 0.",
+	)
+	cmdInstance.Flags().UintSliceVar(
+		&f.dumpConnections,
+		"dump-conn",
+		nil,
+		"only dump these comma-separated connection IDs. Dumps all if empty.",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&f.dumpClients,
+		"dump-client",
+		nil,
+		"only dump TCP clients from these comma-separated CIDRs or IPs. Dumps all if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.capturePrefix,
+		"capture",
+		"",
+		"capture relayed connections to `<capture>-<timestamp>.pcapng` files, e.g /var/tmp/gocat. Disabled if empty.",
+	)
+	cmdInstance.Flags().BoolVar(
+		&f.capturePerConnection,
+		"capture-per-conn",
+		false,
+		"capture each connection to its own `<capture>-conn<ID>-<timestamp>.pcapng` files",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.captureMaxSize,
+		"capture-max-size",
+		"",
+		"start a new capture file once the current one exceeds this size, e.g 100M. Never if empty.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.captureMaxAge,
+		"capture-max-age",
+		0,
+		"start a new capture file once the current one is this old, e.g 1h. Never if 0.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.recordFile,
+		"record",
+		"",
+		"append relayed sessions with their timing to this file, for `gocat replay`. Disabled if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.relayLogLevel,
+		"relay-log-level",
+		"",
+		"log level of the relay, overriding `log-level` for its connections, e.g debug. Same as `log-level` if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.accessLogPath,
+		"access-log",
+		"",
+		"write a line per finished connection to this file, reopened on SIGUSR1. Disabled if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.accessLogTemplate,
+		"access-log-template",
+		relay.DefaultAccessLogTemplate,
+		"Go template of access log lines, with the fields Relay, Connection, Client, Destination, Source, "+
+			"Start, End, Duration, BytesIn, BytesOut and Reason",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.accessLogMaxSize,
+		"access-log-max-size",
+		"",
+		"rotate the access log once it would exceed this size, e.g 100M. Never if empty.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.accessLogMaxAge,
+		"access-log-max-age",
+		0,
+		"rotate the access log once it's this old, e.g 24h. Never if 0.",
+	)
+	cmdInstance.Flags().BoolVar(
+		&f.accessLogCompress,
+		"access-log-compress",
+		false,
+		"gzip rotated access logs",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.otlpEndpoint,
+		"otlp-endpoint",
+		"",
+		"export a tracing span per connection to this OTLP/HTTP collector, e.g http://localhost:4318. Disabled if empty.",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&f.otlpHeaders,
+		"otlp-header",
+		nil,
+		"header of OTLP export requests as key=value, e.g for authentication. Can be repeated.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.otlpServiceName,
+		"otlp-service-name",
+		"gocat",
+		"service name of exported spans",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.otlpFlushInterval,
+		"otlp-flush-interval",
+		5*time.Second,
+		"how often to export the spans of closed connections, e.g 5s.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.statsDAddress,
+		"statsd-address",
+		"",
+		"push metrics to this StatsD agent, e.g udp://127.0.0.1:8125 or unixgram:///var/run/datadog/dsd.socket. "+
+			"Disabled if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.statsDPrefix,
+		"statsd-prefix",
+		"gocat",
+		"prefix of pushed metric names. No prefix if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.statsDFlavor,
+		"statsd-flavor",
+		metrics.FlavorDogStatsD,
+		"dogstatsd to send tags such as the relay name as DogStatsD tags, "+
+			"statsd to append their values to metric names instead",
+	)
+	cmdInstance.Flags().DurationVar(
+		&f.statsDFlushInterval,
+		"statsd-flush-interval",
+		time.Second,
+		"how often to push buffered metrics, e.g 1s.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.adminSocket,
+		"admin-socket",
+		"",
+		"unix socket to serve the admin API at, used by `gocat ctl`. Disabled if empty.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.adminListen,
+		"admin-listen",
+		"",
+		"TCP address to also serve the admin API at over HTTP, e.g 127.0.0.1:9101. Disabled if empty. "+
+			"Addresses other than loopback ones require `--admin-token-file`.",
+	)
+	cmdInstance.Flags().StringVar(
+		&f.adminTokenFile,
+		"admin-token-file",
+		"",
+		"file with the bearer token required by the admin API at `--admin-listen`, "+
+			"passed to `gocat ctl --token-file`.",
+	)
+}
+
+func (f *relayFlags) options(logger logger.Logger) ([]relay.Option, error) {
+	if f.breakerFailureRatio < 0 || f.breakerFailureRatio > 1 {
+		return nil, stacktrace.NewError(
+			"invalid `circuit-breaker-failure-ratio` %v. Expected a value between 0 and 1",
+			f.breakerFailureRatio,
+		)
+	}
+
+	opts := []relay.Option{
+		relay.WithName(f.name),
+		relay.WithCircuitBreaker(relay.CircuitBreakerConfig{
+			ConsecutiveFailures: f.breakerConsecutiveFailures,
+			FailureRatio:        f.breakerFailureRatio,
+			RatioWindow:         f.breakerRatioWindow,
+			Cooldown:            f.breakerCooldown,
+		}),
+		relay.WithSourcePool(f.sourcePoolSize, f.sourcePoolMaxIdle),
+		relay.WithConnectionLimits(relay.ConnectionLimits{
+			MaxConnections: f.maxConnections,
+			MaxPerClient:   f.maxConnectionsPerClient,
+			QueueTimeout:   f.connectionQueueTimeout,
+		}),
+		relay.WithRateLimits(relay.RateLimits{
+			Rate:        f.rateLimit,
+			Burst:       f.rateLimitBurst,
+			ClientRate:  f.clientRateLimit,
+			ClientBurst: f.clientRateBurst,
+			BanAfter:    f.clientBanAfter,
+			BanDuration: f.clientBanDuration,
+		}),
+	}
+
+	if len(f.allowCIDRs) > 0 || len(f.denyCIDRs) > 0 || f.ipRulesFile != "" {
+		ipFilter, err := relay.NewIPFilter(f.allowCIDRs, f.denyCIDRs, f.ipRulesFile)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid IP rules")
+		}
+
+		f.ipFilter = ipFilter
+		opts = append(opts, relay.WithIPFilter(ipFilter))
+	}
+
+	opening, err := f.clientOpening()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, relay.WithClientOpening(opening))
+
+	if f.dump {
+		dumper, err := f.trafficDumper()
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, relay.WithTrafficDumper(dumper))
+	}
+
+	if f.capturePrefix != "" {
+		maxSize, err := relay.ParseByteSize(f.captureMaxSize)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `capture-max-size` specified")
+		}
+
+		f.capturer = relay.NewCapturer(
+			relay.Capture{
+				Prefix:        f.capturePrefix,
+				PerConnection: f.capturePerConnection,
+				MaxFileSize:   maxSize,
+				MaxFileAge:    f.captureMaxAge,
+			},
+			logger,
+		)
+		opts = append(opts, relay.WithCapturer(f.capturer))
+	}
+
+	if f.relayLogLevel != "" {
+		level, err := logging.ParseLevel(f.relayLogLevel)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `relay-log-level` specified")
+		}
+
+		opts = append(opts, relay.WithLogLevel(level))
+	}
+
+	if f.accessLogPath != "" {
+		accessLog, err := f.accessLog(logger)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, relay.WithAccessLog(accessLog))
+	}
+
+	if f.otlpEndpoint != "" {
+		headers, err := tracing.ParseHeaders(f.otlpHeaders)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `otlp-header` specified")
+		}
+
+		tracer, err := tracing.NewExporter(
+			tracing.ExporterConfig{
+				Endpoint:      f.otlpEndpoint,
+				ServiceName:   f.otlpServiceName,
+				Headers:       headers,
+				FlushInterval: f.otlpFlushInterval,
+			},
+			logger,
+		)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `otlp-endpoint` specified")
+		}
+
+		f.tracer = tracer
+		opts = append(opts, relay.WithTracer(tracer))
+	}
+
+	if f.recordFile != "" {
+		sessionRecorder, err := relay.NewSessionRecorder(f.recordFile, logger)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `record` specified")
+		}
+
+		f.sessionRecorder = sessionRecorder
+		opts = append(opts, relay.WithSessionRecorder(sessionRecorder))
+	}
+
+	limits, err := f.bandwidthLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	if limits != (relay.BandwidthLimits{}) || f.bandwidthFile != "" {
+		shaper, err := relay.NewBandwidthShaper(limits, f.bandwidthFile)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid bandwidth limits")
+		}
+
+		f.shaper = shaper
+		opts = append(opts, relay.WithBandwidthShaper(shaper))
+	}
+
+	var recorders metrics.Multi
+	if f.metricsListen != "" {
+		f.metricsRegistry = metrics.NewRegistry()
+		recorders = append(recorders, f.metricsRegistry)
+	}
+
+	if f.statsDAddress != "" {
+		statsD, err := metrics.NewStatsD(
+			metrics.StatsDConfig{
+				Address:       f.statsDAddress,
+				Prefix:        f.statsDPrefix,
+				Flavor:        f.statsDFlavor,
+				FlushInterval: f.statsDFlushInterval,
+			},
+			logger,
+		)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid `statsd-address` or `statsd-flavor` specified")
+		}
+
+		f.statsD = statsD
+		recorders = append(recorders, statsD)
+	}
+
+	switch len(recorders) {
+	case 0:
+	case 1:
+		opts = append(opts, relay.WithMetrics(recorders[0]))
+	default:
+		opts = append(opts, relay.WithMetrics(recorders))
+	}
+
+	return opts, nil
+}
+
+func (f *relayFlags) trafficDumper() (*relay.TrafficDumper, error) {
+	config := relay.TrafficDump{
+		Writer:             os.Stderr,
+		MaxChunkBytes:      f.dumpMaxChunkBytes,
+		MaxConnectionBytes: f.dumpMaxConnBytes,
+		ClientCIDRs:        f.dumpClients,
+	}
+
+	for _, id := range f.dumpConnections {
+		config.ConnectionIDs = append(config.ConnectionIDs, uint64(id))
+	}
+
+	if f.dumpFile != "" {
+		fd, err := os.OpenFile(f.dumpFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed to open `dump-file` %s", f.dumpFile)
+		}
+
+		f.dumpFileFd = fd
+		config.Writer = fd
+	}
+
+	dumper, err := relay.NewTrafficDumper(config)
+	if err != nil {
+		if f.dumpFileFd != nil {
+			_ = f.dumpFileFd.Close()
+			f.dumpFileFd = nil
+		}
+
+		return nil, stacktrace.Propagate(err, "invalid `dump-client` specified")
+	}
+
+	return dumper, nil
+}
+
+func (f *relayFlags) clientOpening() (relay.ClientOpening, error) {
+	opening := relay.ClientOpening{
+		FirstByteTimeout: f.firstByteTimeout,
+		DeferDial:        f.deferDial,
+		HandshakeMaxSize: f.handshakeMaxSize,
+	}
+
+	if f.handshakeDelimiter != "" {
+		delimiter, err := strconv.Unquote(`"` + f.handshakeDelimiter + `"`)
+		if err != nil {
+			return opening, stacktrace.Propagate(err, "invalid `handshake-delimiter` specified")
+		}
+
+		opening.HandshakeDelimiter = []byte(delimiter)
+	}
+
+	return opening, nil
+}
+
+func (f *relayFlags) bandwidthLimits() (relay.BandwidthLimits, error) {
+	var limits relay.BandwidthLimits
+
+	flagLimits := []struct {
+		name  string
+		value string
+		limit *int64
+	}{
+		{"upload-limit", f.uploadLimit, &limits.Upload},
+		{"download-limit", f.downloadLimit, &limits.Download},
+		{"conn-upload-limit", f.connectionUploadLimit, &limits.ConnectionUpload},
+		{"conn-download-limit", f.connectionDownloadLimit, &limits.ConnectionDownload},
+	}
+
+	for _, flagLimit := range flagLimits {
+		rate, err := relay.ParseByteSize(flagLimit.value)
+		if err != nil {
+			return limits, stacktrace.Propagate(err, "invalid `%s` specified", flagLimit.name)
+		}
+
+		*flagLimit.limit = rate
+	}
+
+	return limits, nil
+}
+
+func (f *relayFlags) accessLog(logger logger.Logger) (*relay.AccessLog, error) {
+	maxSize, err := relay.ParseByteSize(f.accessLogMaxSize)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid `access-log-max-size` specified")
+	}
+
+	file, err := logfile.Open(
+		f.accessLogPath,
+		logfile.Rotation{
+			MaxSize:  maxSize,
+			MaxAge:   f.accessLogMaxAge,
+			Compress: f.accessLogCompress,
+		},
+	)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid `access-log` specified")
+	}
+
+	file.OnError = func(err error) {
+		logging.WithError(logger, err).Errorf("Could not rotate access log %s", f.accessLogPath)
+	}
+
+	accessLog, err := relay.NewAccessLog(file, f.accessLogTemplate)
+	if err != nil {
+		_ = file.Close()
+		return nil, stacktrace.Propagate(err, "invalid `access-log-template` specified")
+	}
+
+	f.accessLogFile = file
+
+	return accessLog, nil
+}
+
+// start runs the background services configured by the flags until `ctx` is done.
+func (f *relayFlags) start(ctx context.Context, logger logger.Logger, relayer admin.Relay) error {
+	if f.ipFilter != nil || f.shaper != nil {
+		go f.reloadOnSignal(ctx, logger)
+	}
+
+	if f.accessLogFile != nil {
+		go f.reopenOnSignal(ctx, logger)
+	}
+
+	err := f.startAdmin(ctx, logger, relayer)
+	if err != nil {
+		return err
+	}
+
+	if f.metricsRegistry == nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", f.metricsListen)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to listen for metrics at %s", f.metricsListen)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", f.metricsRegistry)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logging.WithError(logger, err).Errorf("Could not serve metrics at %s", f.metricsListen)
+		}
+	}()
+
+	return nil
+}
+
+// startAdmin serves the admin API of `relayer` at the configured unix socket and TCP address.
+func (f *relayFlags) startAdmin(ctx context.Context, logger logger.Logger, relayer admin.Relay) error {
+	server := admin.NewServer(logger, relayer)
+
+	if f.adminSocket != "" {
+		listener, err := admin.ListenUnix(ctx, logger, f.adminSocket)
+		if err != nil {
+			return stacktrace.Propagate(err, "failed to listen for admin API at %s", f.adminSocket)
+		}
+
+		f.adminListeners = append(f.adminListeners, listener)
+		go server.Serve(ctx, listener, "")
+	}
+
+	if f.adminListen != "" {
+		var token string
+		if f.adminTokenFile != "" {
+			var err error
+			token, err = readTokenFile(f.adminTokenFile)
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid `admin-token-file` specified")
+			}
+		} else if !isLoopbackAddress(f.adminListen) {
+			return stacktrace.NewError(
+				"refusing to serve the admin API at %s without `admin-token-file`, "+
+					"anybody reaching it could kill connections and pause the relay",
+				f.adminListen,
+			)
+		}
+
+		listener, err := net.Listen("tcp", f.adminListen)
+		if err != nil {
+			return stacktrace.Propagate(err, "failed to listen for admin API at %s", f.adminListen)
+		}
+
+		f.adminListeners = append(f.adminListeners, listener)
+		go server.Serve(ctx, listener, token)
+	}
+
+	return nil
+}
+
+// isLoopbackAddress tells whether the TCP `address` is only reachable from this host.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// readTokenFile reads the admin API token from `path`, ignoring surrounding whitespace.
+func readTokenFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", stacktrace.Propagate(err, "failed to read %s", path)
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", stacktrace.NewError("%s is blank/empty", path)
+	}
+
+	return token, nil
+}
+
+// close flushes and closes the outputs and listeners configured by the flags, once the relay stopped.
+// It's safe to call if `options` failed half-way or `start` never ran.
+func (f *relayFlags) close() {
+	// NOTE: Removes the admin unix socket file.
+	for _, listener := range f.adminListeners {
+		_ = listener.Close()
+	}
+
+	if f.dumpFileFd != nil {
+		_ = f.dumpFileFd.Close()
+	}
+
+	if f.capturer != nil {
+		_ = f.capturer.Close()
+	}
+
+	if f.sessionRecorder != nil {
+		_ = f.sessionRecorder.Close()
+	}
+
+	if f.accessLogFile != nil {
+		_ = f.accessLogFile.Close()
+	}
+
+	if f.tracer != nil {
+		_ = f.tracer.Close()
+	}
+
+	if f.statsD != nil {
+		_ = f.statsD.Close()
+	}
+}
+
+// reloadOnSignal reloads the IP rules and bandwidth limits files on SIGHUP.
+func (f *relayFlags) reloadOnSignal(ctx context.Context, logger logger.Logger) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP)
+	defer signal.Stop(signalCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signalCh:
+			f.reloadIPRules(logger)
+			f.reloadBandwidthLimits(logger)
+		}
+	}
+}
+
+// reopenOnSignal reopens the access log on SIGUSR1, e.g after logrotate moved it.
+func (f *relayFlags) reopenOnSignal(ctx context.Context, logger logger.Logger) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGUSR1)
+	defer signal.Stop(signalCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signalCh:
+			err := f.accessLogFile.Reopen()
+			if err != nil {
+				logging.WithError(logger, err).Errorf(
+					"Could not reopen access log %s, still writing to the previous file",
+					f.accessLogPath,
+				)
+				continue
+			}
+
+			logger.Infof("Reopened access log %s", f.accessLogPath)
+		}
+	}
+}
+
+func (f *relayFlags) reloadIPRules(logger logger.Logger) {
+	// NOTE: Rules given only as flags can't change.
+	if f.ipFilter == nil || f.ipRulesFile == "" {
+		return
+	}
+
+	err := f.ipFilter.Reload()
+	if err != nil {
+		logging.WithError(logger, err).Errorf("Could not reload IP rules. Keeping previous rules")
+		return
+	}
+
+	logger.Infof("Reloaded IP rules from %s", f.ipRulesFile)
+}
+
+func (f *relayFlags) reloadBandwidthLimits(logger logger.Logger) {
+	if f.shaper == nil || f.bandwidthFile == "" {
+		return
+	}
+
+	err := f.shaper.Reload()
+	if err != nil {
+		logging.WithError(logger, err).Errorf("Could not reload bandwidth limits. Keeping previous limits")
+		return
+	}
+
+	logger.Infof("Reloaded bandwidth limits from %s", f.bandwidthFile)
+}
+
+// parseFileMode parses an octal file mode, e.g 0660. Empty is 0.
+func parseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, stacktrace.NewError("invalid octal file mode %s", value)
+	}
+
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/spf13/cobra"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/recording"
+)
+
+func NewReplayCmd(logger logger.Logger) *cobra.Command {
+	var recordingFile string
+	var target string
+	var targetNetwork string
+	var speed float64
+	var connectionIDs []uint
+	var runs []string
+	var responseTimeout time.Duration
+
+	cmdInstance := &cobra.Command{
+		Use:   "replay",
+		Short: "replay recorded sessions against a target",
+		Long: `replay the client side of sessions recorded with ` + "`--record`" + ` against a TCP or unix target,
+reporting where the target responses diverge from the recorded ones.`,
+		RunE: func(command *cobra.Command, args []string) error {
+			if targetNetwork != "tcp" && targetNetwork != "unix" {
+				return stacktrace.NewError("invalid `target-network` specified, expected tcp or unix")
+			}
+
+			if speed < 0 {
+				return stacktrace.NewError("invalid `speed` specified, must not be negative")
+			}
+
+			fd, err := os.Open(recordingFile)
+			if err != nil {
+				return stacktrace.Propagate(err, "failed to open recording file %s", recordingFile)
+			}
+			defer fd.Close()
+
+			sessions, err := recording.ReadSessions(fd)
+			if err != nil {
+				return stacktrace.Propagate(err, "failed to read recording file %s", recordingFile)
+			}
+
+			sessions = filterSessions(sessions, runs, connectionIDs)
+			if len(sessions) == 0 {
+				return stacktrace.NewError("no sessions to replay in %s", recordingFile)
+			}
+
+			osSignalCh := make(chan os.Signal, 1)
+			defer close(osSignalCh)
+
+			signal.Notify(osSignalCh, os.Interrupt, syscall.SIGTERM)
+
+			ctx, cancelFunc := context.WithCancel(context.Background())
+			defer cancelFunc()
+
+			// Ctrl+C handler
+			go func() {
+				<-osSignalCh
+				signal.Stop(osSignalCh)
+				cancelFunc()
+			}()
+
+			var dialer net.Dialer
+			replayer := &recording.Replayer{
+				Dial: func(ctx context.Context) (net.Conn, error) {
+					return dialer.DialContext(ctx, targetNetwork, target)
+				},
+				Speed:           speed,
+				ResponseTimeout: responseTimeout,
+			}
+
+			failed := replaySessions(ctx, logger, replayer, sessions)
+			if failed > 0 {
+				return stacktrace.NewError("%d out of %d replayed sessions diverged or failed", failed, len(sessions))
+			}
+
+			logger.Infof("Replayed %d sessions without divergence", len(sessions))
+
+			return nil
+		},
+	}
+
+	cmdInstance.Flags().StringVar(
+		&recordingFile,
+		"file",
+		"",
+		"recording file written by `--record`",
+	)
+	_ = cmdInstance.MarkFlagRequired("file")
+	cmdInstance.Flags().StringVar(
+		&target,
+		"target",
+		"",
+		"TCP address or unix domain socket path to replay against",
+	)
+	_ = cmdInstance.MarkFlagRequired("target")
+	cmdInstance.Flags().StringVar(
+		&targetNetwork,
+		"target-network",
+		"tcp",
+		"network of `target`, tcp or unix",
+	)
+	cmdInstance.Flags().Float64Var(
+		&speed,
+		"speed",
+		1,
+		"timing multiplier, e.g 2 replays twice as fast as recorded. As fast as possible if 0.",
+	)
+	cmdInstance.Flags().UintSliceVar(
+		&connectionIDs,
+		"conn",
+		nil,
+		"only replay the sessions of these connection IDs. All if empty.",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&runs,
+		"run",
+		nil,
+		"only replay the sessions recorded by these runs, i.e gocat processes, "+
+			"as in the `run` field of the recording. All if empty.",
+	)
+	cmdInstance.Flags().DurationVar(
+		&responseTimeout,
+		"response-timeout",
+		5*time.Second,
+		"how long to wait for the recorded responses of the target, e.g 500ms, 5s.",
+	)
+
+	return cmdInstance
+}
+
+func filterSessions(sessions []*recording.Session, runs []string, connectionIDs []uint) []*recording.Session {
+	if len(runs) == 0 && len(connectionIDs) == 0 {
+		return sessions
+	}
+
+	wantedRuns := make(map[string]bool, len(runs))
+	for _, run := range runs {
+		wantedRuns[run] = true
+	}
+
+	wantedConnections := make(map[uint64]bool, len(connectionIDs))
+	for _, id := range connectionIDs {
+		wantedConnections[uint64(id)] = true
+	}
+
+	var result []*recording.Session
+	for _, session := range sessions {
+		if len(runs) > 0 && !wantedRuns[session.Run] {
+			continue
+		}
+
+		if len(connectionIDs) > 0 && !wantedConnections[session.Connection] {
+			continue
+		}
+
+		result = append(result, session)
+	}
+
+	return result
+}
+
+// replaySessions replays the sessions concurrently, keeping their recorded relative start,
+// and returns how many of them diverged or failed.
+func replaySessions(
+	ctx context.Context,
+	logger logger.Logger,
+	replayer *recording.Replayer,
+	sessions []*recording.Session,
+) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+
+	start := time.Now()
+	first := sessions[0].Start()
+	for _, session := range sessions {
+		if replayer.Speed > 0 {
+			offset := time.Duration(float64(session.Start().Sub(first)) / replayer.Speed)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		}
+
+		wg.Add(1)
+		go func(session *recording.Session) {
+			defer wg.Done()
+
+			divergence, err := replayer.Replay(ctx, session)
+			switch {
+			case err != nil:
+				logging.WithError(logger, err).Errorf("Could not replay %s", session)
+			case divergence != nil:
+				logger.Errorf("Replay diverged: %s", divergence)
+			default:
+				logger.Infof("Replayed %s as recorded", session)
+				return
+			}
+
+			mu.Lock()
+			failed++
+			mu.Unlock()
+		}(session)
+	}
+
+	wg.Wait()
+
+	return failed
+}
@@ -15,10 +15,11 @@
 package cmd
 
 import (
-	"github.com/sumup-oss/go-pkgs/logger"
 	"github.com/sumup-oss/go-pkgs/os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/sumup-oss/gocat/internal/logging"
 )
 
 const (
@@ -26,7 +27,9 @@ const (
 	DefaultBufferSize = 16384
 )
 
-func NewRootCmd(osExecutor os.OsExecutor, logger logger.Logger) *cobra.Command {
+func NewRootCmd(osExecutor os.OsExecutor, logger *logging.Logger, sinkConfig logging.SinkConfig) *cobra.Command {
+	var flags logFlags
+
 	cmdInstance := &cobra.Command{
 		Use:   "gocat",
 		Short: "gocat cli utility",
@@ -36,13 +39,21 @@ func NewRootCmd(osExecutor os.OsExecutor, logger logger.Logger) *cobra.Command {
 		// that it's going to log either way.
 		SilenceErrors: true,
 		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return flags.apply(logger, sinkConfig)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
 		},
 	}
 
+	flags.register(cmdInstance)
+
 	cmdInstance.AddCommand(
+		NewConnectConnectCmd(logger),
+		NewCtlCmd(osExecutor),
 		NewFakeCmd(logger),
+		NewReplayCmd(logger),
 		NewTCPToUnixCmd(logger),
 		NewUnixToTCPCmd(logger),
 		NewVersionCmd(osExecutor),
@@ -0,0 +1,58 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/sumup-oss/gocat/internal/relay"
+)
+
+// seqPacketFlags configure `SOCK_SEQPACKET` unix socket relaying.
+type seqPacketFlags struct {
+	enabled      bool
+	lengthPrefix bool
+}
+
+// register adds the flags, `side` being the flag prefix (src or dst) of the unix socket.
+func (f *seqPacketFlags) register(cmdInstance *cobra.Command, side string) {
+	cmdInstance.Flags().BoolVar(
+		&f.enabled,
+		side+"-seqpacket",
+		false,
+		"use a SOCK_SEQPACKET "+side+" unix socket, relaying each packet as a single write. "+
+			"Connections sending a packet larger than the buffer size are closed.",
+	)
+	cmdInstance.Flags().BoolVar(
+		&f.lengthPrefix,
+		"length-prefix",
+		false,
+		"frame each packet of the seqpacket "+side+" with a 4 bytes big-endian length on the other side, "+
+			"preserving message boundaries end to end",
+	)
+}
+
+func (f *seqPacketFlags) options() []relay.Option {
+	var result []relay.Option
+	if f.enabled {
+		result = append(result, relay.WithSeqPacket())
+	}
+
+	if f.lengthPrefix {
+		result = append(result, relay.WithLengthPrefixFraming())
+	}
+
+	return result
+}
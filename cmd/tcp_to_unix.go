@@ -32,7 +32,22 @@ func NewTCPToUnixCmd(logger logger.Logger) *cobra.Command {
 	var tcpToUnixSocketPath string
 	var tcpToUnixAddressPath string
 	var bufferSize int
+	var flags relayFlags
+	var packetFlags seqPacketFlags
+	var multiplexFlags muxFlags
 	var tcpToUnixHealthCheckInterval time.Duration
+	var srcResolveMode string
+	var srcResolveInterval time.Duration
+	var dnsServer string
+	var allowPeerUsers []string
+	var allowPeerGroups []string
+	var allowPeerExecutables []string
+	var dstMode string
+	var dstOwner string
+	var dstGroup string
+	var dstCreateDir bool
+	var dstDirMode string
+	var dstLockFile bool
 
 	cmdInstance := &cobra.Command{
 		Use:   "tcp-to-unix",
@@ -49,12 +64,72 @@ func NewTCPToUnixCmd(logger logger.Logger) *cobra.Command {
 				return stacktrace.NewError("blank/empty `dst` specified")
 			}
 
+			resolveMode, err := relay.ParseSourceResolveMode(srcResolveMode)
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid `src-resolve` specified")
+			}
+
+			opts, err := flags.options(logger)
+			// NOTE: Also closes what `options` opened before failing.
+			defer flags.close()
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid relay flags specified")
+			}
+
+			muxOpts, err := multiplexFlags.options(false)
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid mux flags specified")
+			}
+
+			opts = append(opts, muxOpts...)
+			opts = append(opts, packetFlags.options()...)
+
+			opts = append(
+				opts,
+				relay.WithSourceResolution(resolveMode, srcResolveInterval),
+				relay.WithDNSServer(dnsServer),
+			)
+
+			if dstMode != "" || dstOwner != "" || dstGroup != "" || dstCreateDir {
+				socketMode, err := parseFileMode(dstMode)
+				if err != nil {
+					return stacktrace.Propagate(err, "invalid `dst-mode` specified")
+				}
+
+				socketDirMode, err := parseFileMode(dstDirMode)
+				if err != nil {
+					return stacktrace.Propagate(err, "invalid `dst-dir-mode` specified")
+				}
+
+				opts = append(opts, relay.WithUnixSocketPermissions(relay.UnixSocketPermissions{
+					Mode:      socketMode,
+					Owner:     dstOwner,
+					Group:     dstGroup,
+					CreateDir: dstCreateDir,
+					DirMode:   socketDirMode,
+				}))
+			}
+
+			if dstLockFile {
+				opts = append(opts, relay.WithUnixSocketLock())
+			}
+
+			if len(allowPeerUsers) > 0 || len(allowPeerGroups) > 0 || len(allowPeerExecutables) > 0 {
+				peerAuthorizer, err := relay.NewPeerAuthorizer(allowPeerUsers, allowPeerGroups, allowPeerExecutables)
+				if err != nil {
+					return stacktrace.Propagate(err, "invalid `allow-peer-*` specified")
+				}
+
+				opts = append(opts, relay.WithPeerAuthorizer(peerAuthorizer))
+			}
+
 			relayer, err := relay.NewTCPtoUnixSocket(
 				logger,
 				tcpToUnixHealthCheckInterval,
 				tcpToUnixAddressPath,
 				tcpToUnixSocketPath,
 				bufferSize,
+				opts...,
 			)
 			if err != nil {
 				return stacktrace.Propagate(err, "couldn't create relay from TCP to unix socket")
@@ -68,22 +143,24 @@ func NewTCPToUnixCmd(logger logger.Logger) *cobra.Command {
 			ctx, cancelFunc := context.WithCancel(context.Background())
 			defer cancelFunc()
 
+			err = flags.start(ctx, logger, relayer)
+			if err != nil {
+				return stacktrace.Propagate(err, "couldn't start relay services")
+			}
+
 			// Ctrl+C handler
 			go func() {
 				<-osSignalCh
 				signal.Stop(osSignalCh)
 
-				_ = os.RemoveAll(tcpToUnixSocketPath)
 				cancelFunc()
 			}()
 
 			err = relayer.Relay(ctx)
 			if err != nil {
-				_ = os.RemoveAll(tcpToUnixSocketPath)
 				return stacktrace.Propagate(err, "couldn't relay from TCP to unix socket")
 			}
 
-			_ = os.RemoveAll(tcpToUnixSocketPath)
 			return nil
 		},
 	}
@@ -96,6 +173,25 @@ func NewTCPToUnixCmd(logger logger.Logger) *cobra.Command {
 	)
 	cmdInstance.Flags().StringVar(&tcpToUnixAddressPath, "src", "", "source of TCP address")
 	_ = cmdInstance.MarkFlagRequired("src")
+	cmdInstance.Flags().StringVar(
+		&srcResolveMode,
+		"src-resolve",
+		string(relay.SourceResolveStatic),
+		"resolution mode of `src`. One of static, dns (spread across all A/AAAA records) "+
+			"or srv (`src` is a SRV record name, e.g _ssh._tcp.example).",
+	)
+	cmdInstance.Flags().DurationVar(
+		&srcResolveInterval,
+		"src-resolve-interval",
+		30*time.Second,
+		"re-resolution interval of `src` for dns and srv resolution modes.",
+	)
+	cmdInstance.Flags().StringVar(
+		&dnsServer,
+		"dns-server",
+		"",
+		"DNS server <addr>:<port> used to resolve `src` instead of the system resolvers.",
+	)
 	cmdInstance.Flags().StringVar(
 		&tcpToUnixSocketPath,
 		"dst",
@@ -109,5 +205,55 @@ func NewTCPToUnixCmd(logger logger.Logger) *cobra.Command {
 		DefaultBufferSize,
 		"Buffer size in bytes of the data stream",
 	)
+	cmdInstance.Flags().StringVar(
+		&dstMode,
+		"dst-mode",
+		"",
+		"octal file mode of the `dst` unix domain socket, e.g 0660. Defaults to the process umask.",
+	)
+	cmdInstance.Flags().StringVar(&dstOwner, "dst-owner", "", "owner user name or UID of the `dst` unix domain socket")
+	cmdInstance.Flags().StringVar(&dstGroup, "dst-group", "", "group name or GID of the `dst` unix domain socket")
+	cmdInstance.Flags().BoolVar(
+		&dstCreateDir,
+		"dst-create-dir",
+		false,
+		"create missing parent directories of the `dst` unix domain socket",
+	)
+	cmdInstance.Flags().StringVar(
+		&dstDirMode,
+		"dst-dir-mode",
+		"0755",
+		"octal file mode of parent directories created by `dst-create-dir`",
+	)
+	cmdInstance.Flags().BoolVar(
+		&dstLockFile,
+		"dst-lock-file",
+		false,
+		"hold an exclusive lock of `dst`.lock while listening, so only one instance can serve `dst`",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&allowPeerUsers,
+		"allow-peer-user",
+		nil,
+		"only accept `dst` clients running as one of these comma-separated user names or UIDs",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&allowPeerGroups,
+		"allow-peer-group",
+		nil,
+		"only accept `dst` clients in one of these comma-separated group names or GIDs, "+
+			"including supplementary groups",
+	)
+	cmdInstance.Flags().StringSliceVar(
+		&allowPeerExecutables,
+		"allow-peer-exe",
+		nil,
+		"only accept `dst` clients running one of these comma-separated absolute executable paths. "+
+			"Read from /proc after the client connected, see README.",
+	)
+	flags.register(cmdInstance)
+	packetFlags.register(cmdInstance, "dst")
+	multiplexFlags.register(cmdInstance)
+
 	return cmdInstance
 }
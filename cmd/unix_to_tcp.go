@@ -32,6 +32,9 @@ func NewUnixToTCPCmd(logger logger.Logger) *cobra.Command {
 	var unixToTCPSocketPath string
 	var unixToTCPAddressPath string
 	var bufferSize int
+	var flags relayFlags
+	var packetFlags seqPacketFlags
+	var multiplexFlags muxFlags
 	var unixToTCPHealthCheckDuration time.Duration
 
 	cmdInstance := &cobra.Command{
@@ -49,12 +52,28 @@ func NewUnixToTCPCmd(logger logger.Logger) *cobra.Command {
 				return stacktrace.NewError("blank/empty `dst` specified")
 			}
 
+			opts, err := flags.options(logger)
+			// NOTE: Also closes what `options` opened before failing.
+			defer flags.close()
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid relay flags specified")
+			}
+
+			muxOpts, err := multiplexFlags.options(true)
+			if err != nil {
+				return stacktrace.Propagate(err, "invalid mux flags specified")
+			}
+
+			opts = append(opts, muxOpts...)
+			opts = append(opts, packetFlags.options()...)
+
 			relayer, err := relay.NewUnixSocketTCP(
 				logger,
 				unixToTCPHealthCheckDuration,
 				unixToTCPSocketPath,
 				unixToTCPAddressPath,
 				bufferSize,
+				opts...,
 			)
 			if err != nil {
 				return stacktrace.Propagate(err, "couldn't create relay from unix socket to TCP")
@@ -68,6 +87,11 @@ func NewUnixToTCPCmd(logger logger.Logger) *cobra.Command {
 			ctx, cancelFunc := context.WithCancel(context.Background())
 			defer cancelFunc()
 
+			err = flags.start(ctx, logger, relayer)
+			if err != nil {
+				return stacktrace.Propagate(err, "couldn't start relay services")
+			}
+
 			// Ctrl+C handler
 			go func() {
 				<-osSignalCh
@@ -106,6 +130,9 @@ func NewUnixToTCPCmd(logger logger.Logger) *cobra.Command {
 		DefaultBufferSize,
 		"Buffer size in bytes of the data stream",
 	)
+	flags.register(cmdInstance)
+	packetFlags.register(cmdInstance, "src")
+	multiplexFlags.register(cmdInstance)
 
 	return cmdInstance
 }
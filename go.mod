@@ -7,6 +7,7 @@ require (
 	github.com/spf13/cobra v0.0.3
 	github.com/stretchr/testify v1.4.0
 	github.com/sumup-oss/go-pkgs v0.0.0-20200306132509-b949afdfe2fe
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 )
 
 replace (
@@ -0,0 +1,79 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"time"
+
+	"github.com/sumup-oss/gocat/internal/relay"
+)
+
+// RelayStatus is a relay as listed by the admin API.
+type RelayStatus struct {
+	Name                string `json:"name"`
+	Source              string `json:"source"`
+	Destination         string `json:"destination"`
+	DestinationAddress  string `json:"destination_address"`
+	Paused              bool   `json:"paused"`
+	ActiveConnections   int    `json:"active_connections"`
+	AcceptedConnections uint64 `json:"accepted_connections"`
+}
+
+// Connection is a relayed connection as listed by the admin API.
+type Connection struct {
+	ID          uint64    `json:"id"`
+	Client      string    `json:"client"`
+	Destination string    `json:"destination"`
+	Source      string    `json:"source"`
+	Accepted    time.Time `json:"accepted"`
+	AgeSeconds  float64   `json:"age_seconds"`
+	BytesIn     uint64    `json:"bytes_in"`
+	BytesOut    uint64    `json:"bytes_out"`
+}
+
+// HealthCheck is the outcome of a health check triggered through the admin API.
+type HealthCheck struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newRelayStatus(info relay.RelayInfo) RelayStatus {
+	return RelayStatus{
+		Name:                info.Name,
+		Source:              info.Source,
+		Destination:         info.Destination,
+		DestinationAddress:  info.DestinationAddress,
+		Paused:              info.Paused,
+		ActiveConnections:   info.ActiveConnections,
+		AcceptedConnections: info.AcceptedConnections,
+	}
+}
+
+func newConnection(info relay.ConnectionInfo, now time.Time) Connection {
+	return Connection{
+		ID:          info.ID,
+		Client:      info.Client,
+		Destination: info.Destination,
+		Source:      info.Source,
+		Accepted:    info.Accepted,
+		AgeSeconds:  now.Sub(info.Accepted).Seconds(),
+		BytesIn:     info.BytesIn,
+		BytesOut:    info.BytesOut,
+	}
+}
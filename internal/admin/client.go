@@ -0,0 +1,162 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// NOTE: Requests over a unix socket still need a host in their URL.
+const unixSocketBaseURL = "http://gocat"
+
+// Client talks to the admin API of a running gocat.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient talks to the admin API at `address`, either a unix socket path or an http(s):// URL.
+// `token` is sent as bearer token, unless it's empty.
+func NewClient(address, token string, timeout time.Duration) (*Client, error) {
+	if address == "" {
+		return nil, stacktrace.NewError("blank/empty admin API address")
+	}
+
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return &Client{
+			baseURL:    strings.TrimSuffix(address, "/"),
+			token:      token,
+			httpClient: &http.Client{Timeout: timeout},
+		}, nil
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", address)
+		},
+	}
+
+	return &Client{
+		baseURL:    unixSocketBaseURL,
+		token:      token,
+		httpClient: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+func (c *Client) Relays(ctx context.Context) ([]RelayStatus, error) {
+	var result []RelayStatus
+	err := c.do(ctx, http.MethodGet, relayPath(), &result)
+
+	return result, err
+}
+
+func (c *Client) Connections(ctx context.Context, relayName string) ([]Connection, error) {
+	var result []Connection
+	err := c.do(ctx, http.MethodGet, relayPath(relayName, "connections"), &result)
+
+	return result, err
+}
+
+func (c *Client) KillConnection(ctx context.Context, relayName string, id uint64) error {
+	return c.do(
+		ctx,
+		http.MethodDelete,
+		relayPath(relayName, "connections", strconv.FormatUint(id, 10)),
+		nil,
+	)
+}
+
+func (c *Client) Pause(ctx context.Context, relayName string) (RelayStatus, error) {
+	var result RelayStatus
+	err := c.do(ctx, http.MethodPost, relayPath(relayName, "pause"), &result)
+
+	return result, err
+}
+
+func (c *Client) Resume(ctx context.Context, relayName string) (RelayStatus, error) {
+	var result RelayStatus
+	err := c.do(ctx, http.MethodPost, relayPath(relayName, "resume"), &result)
+
+	return result, err
+}
+
+func (c *Client) CheckHealth(ctx context.Context, relayName string) (HealthCheck, error) {
+	var result HealthCheck
+	err := c.do(ctx, http.MethodPost, relayPath(relayName, "health-check"), &result)
+
+	return result, err
+}
+
+// do sends a request to `path` and decodes the JSON response into `result`, if not nil.
+func (c *Client) do(ctx context.Context, method, path string, result interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to create admin API request")
+	}
+
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to call admin API")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var apiErr errorResponse
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(body))
+		}
+
+		return stacktrace.NewError("admin API responded with %s: %s", response.Status, apiErr.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(response.Body).Decode(result)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to decode admin API response")
+	}
+
+	return nil
+}
+
+// relayPath escapes every segment of a path under `/relays`.
+func relayPath(segments ...string) string {
+	path := "/relays"
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+
+	return path
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/relay"
+)
+
+const (
+	socketMode         = 0600
+	healthCheckTimeout = 30 * time.Second
+	// NOTE: Keeps clients that never finish their requests, or idle ones, from holding connections open.
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = time.Minute
+)
+
+// Relay is a relay controlled through the admin API.
+type Relay interface {
+	Info() relay.RelayInfo
+	Connections() []relay.ConnectionInfo
+	KillConnection(id uint64) bool
+	Pause()
+	Resume()
+	CheckHealth(ctx context.Context) error
+}
+
+// Server serves the admin API of relays:
+//
+//	GET    /relays
+//	GET    /relays/<name>/connections
+//	DELETE /relays/<name>/connections/<id>
+//	POST   /relays/<name>/pause
+//	POST   /relays/<name>/resume
+//	POST   /relays/<name>/health-check
+type Server struct {
+	relays []Relay
+	logger logger.Logger
+}
+
+func NewServer(logger logger.Logger, relays ...Relay) *Server {
+	return &Server{
+		relays: relays,
+		logger: logger,
+	}
+}
+
+// ListenUnix listens at the unix socket `path`, accessible to its owner only.
+// A stale socket left behind, e.g by a crashed instance, is removed.
+func ListenUnix(ctx context.Context, logger logger.Logger, path string) (net.Listener, error) {
+	return relay.ListenUnixSocket(ctx, logger, path, &relay.UnixSocketPermissions{Mode: socketMode})
+}
+
+// Serve serves the admin API on `listener` until `ctx` is done.
+// Requests need to carry `token` as bearer token, unless it's empty.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, token string) {
+	var handler http.Handler = s
+	if token != "" {
+		handler = requireToken(s, token)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	err := server.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		logging.WithError(s.logger, err).Errorf("Could not serve admin API at %s", listener.Addr())
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r.URL)
+	if err != nil || len(segments) < 1 || segments[0] != "relays" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(segments) == 1 {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+
+		result := make([]RelayStatus, 0, len(s.relays))
+		for _, target := range s.relays {
+			result = append(result, newRelayStatus(target.Info()))
+		}
+
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	target := s.findRelay(segments[1])
+	if target == nil {
+		writeError(w, http.StatusNotFound, "no relay named "+segments[1])
+		return
+	}
+
+	switch {
+	case len(segments) == 3 && segments[2] == "connections":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+
+		now := time.Now()
+		connections := target.Connections()
+		result := make([]Connection, 0, len(connections))
+		for _, connection := range connections {
+			result = append(result, newConnection(connection, now))
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	case len(segments) == 4 && segments[2] == "connections":
+		if !allowMethod(w, r, http.MethodDelete) {
+			return
+		}
+
+		id, err := strconv.ParseUint(segments[3], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid connection ID "+segments[3])
+			return
+		}
+
+		if !target.KillConnection(id) {
+			writeError(w, http.StatusNotFound, "no connection with ID "+segments[3])
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 3 && segments[2] == "pause":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		target.Pause()
+		writeJSON(w, http.StatusOK, newRelayStatus(target.Info()))
+	case len(segments) == 3 && segments[2] == "resume":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		target.Resume()
+		writeJSON(w, http.StatusOK, newRelayStatus(target.Info()))
+	case len(segments) == 3 && segments[2] == "health-check":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		err := target.CheckHealth(ctx)
+		if err != nil {
+			writeJSON(w, http.StatusOK, HealthCheck{Healthy: false, Error: stacktrace.RootCause(err).Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, HealthCheck{Healthy: true})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// requireToken rejects requests to `handler` without `token` as bearer token.
+func requireToken(handler http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) findRelay(name string) Relay {
+	for _, target := range s.relays {
+		if target.Info().Name == name {
+			return target
+		}
+	}
+
+	return nil
+}
+
+// pathSegments splits the path of `u`, unescaping every segment on its own
+// to allow relay names with slashes.
+func pathSegments(u *url.URL) ([]string, error) {
+	var result []string
+	for _, segment := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, unescaped)
+	}
+
+	return result, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -20,7 +20,13 @@ import (
 )
 
 type Config struct {
-	LogLevel string `envconfig:"LOG_LEVEL" default:"INFO"`
+	LogLevel       string `envconfig:"LOG_LEVEL" default:"INFO"`
+	LogFormat      string `envconfig:"LOG_FORMAT" default:"logfmt"`
+	LogSink        string `envconfig:"LOG_SINK" default:"stderr"`
+	SyslogAddress  string `envconfig:"SYSLOG_ADDRESS" default:"unix:///dev/log"`
+	SyslogFacility string `envconfig:"SYSLOG_FACILITY" default:"daemon"`
+	SyslogCAFile   string `envconfig:"SYSLOG_CA_FILE"`
+	JournaldSocket string `envconfig:"JOURNALD_SOCKET" default:"/run/systemd/journal/socket"`
 }
 
 func NewConfig() (*Config, error) {
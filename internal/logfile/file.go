@@ -0,0 +1,274 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logfile implements log files rotated by size or age,
+// optionally compressing rotated files, and reopened for external rotation e.g by logrotate.
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const rotatedTimeFormat = "20060102T150405.000000"
+
+// Rotation configures when a file is rotated.
+type Rotation struct {
+	// MaxSize in bytes of the file before rotating it. Never if 0.
+	MaxSize int64
+	// MaxAge of the file before rotating it. Never if 0.
+	MaxAge time.Duration
+	// Compress rotated files with gzip.
+	Compress bool
+}
+
+// File appends to the file at its path, renaming it to `<path>.<timestamp>` on rotation.
+type File struct {
+	// OnError is called with failures of rotations, including opening the new file,
+	// and background compressions, if set.
+	OnError func(err error)
+
+	path     string
+	rotation Rotation
+
+	mu       sync.Mutex
+	fd       *os.File
+	size     int64
+	openedAt time.Time
+	// rotatedPath is where the current file was rotated to, until a new one could be opened.
+	rotatedPath string
+
+	compressions sync.WaitGroup
+}
+
+// Open opens the file at `path` for appending, creating it if needed.
+func Open(path string, rotation Rotation) (*File, error) {
+	f := &File{
+		path:     path,
+		rotation: rotation,
+	}
+
+	err := f.openLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) openLocked() error {
+	fd, size, err := openFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.swapLocked(fd, size)
+
+	return nil
+}
+
+// swapLocked makes `fd` the current file, closing the previous one.
+func (f *File) swapLocked(fd *os.File, size int64) {
+	if f.fd != nil {
+		err := f.fd.Close()
+		if err != nil {
+			f.reportError(stacktrace.Propagate(err, "failed to close %s", f.path))
+		}
+	}
+
+	f.fd = fd
+	f.size = size
+	f.openedAt = time.Now()
+
+	// NOTE: The rotated file is complete only once the new one took over.
+	if f.rotatedPath != "" && f.rotation.Compress {
+		rotatedPath := f.rotatedPath
+		f.compressions.Add(1)
+		go func() {
+			defer f.compressions.Done()
+
+			err := compress(rotatedPath)
+			if err != nil {
+				f.reportError(err)
+			}
+		}()
+	}
+
+	f.rotatedPath = ""
+}
+
+func openFile(path string) (*os.File, int64, error) {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, 0, stacktrace.Propagate(err, "failed to open %s", path)
+	}
+
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, 0, stacktrace.Propagate(err, "failed to stat %s", path)
+	}
+
+	return fd, info.Size(), nil
+}
+
+// Write writes `p` as a whole to the current file, rotating it beforehand if it's due.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fd == nil {
+		return 0, stacktrace.NewError("%s is closed", f.path)
+	}
+
+	if f.dueLocked(len(p)) {
+		f.rotateLocked()
+	}
+
+	n, err := f.fd.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, stacktrace.Propagate(err, "failed to write to %s", f.path)
+	}
+
+	return n, nil
+}
+
+func (f *File) dueLocked(size int) bool {
+	if f.rotation.MaxSize > 0 && f.size > 0 && f.size+int64(size) > f.rotation.MaxSize {
+		return true
+	}
+
+	return f.rotation.MaxAge > 0 && time.Since(f.openedAt) >= f.rotation.MaxAge
+}
+
+// rotateLocked renames the file and opens a new one at its path.
+// NOTE: If renaming or opening the new file fails, e.g the directory became read-only,
+// it keeps appending to the current file rather than failing every following write.
+func (f *File) rotateLocked() {
+	// NOTE: A previous rotation whose new file failed to open only needs to open it.
+	if f.rotatedPath == "" {
+		rotatedPath := f.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+		err := os.Rename(f.path, rotatedPath)
+		if err != nil {
+			f.reportError(stacktrace.Propagate(err, "failed to rotate %s", f.path))
+
+			// NOTE: Reopened in case the file was removed, appending to the same file otherwise.
+			fd, size, err := openFile(f.path)
+			if err != nil {
+				f.reportError(err)
+			} else {
+				f.swapLocked(fd, size)
+			}
+
+			f.postponeRotationLocked()
+			return
+		}
+
+		f.rotatedPath = rotatedPath
+	}
+
+	fd, size, err := openFile(f.path)
+	if err != nil {
+		f.reportError(stacktrace.Propagate(err, "failed to open new %s after rotating it", f.path))
+		f.postponeRotationLocked()
+		return
+	}
+
+	f.swapLocked(fd, size)
+}
+
+// postponeRotationLocked retries a failed rotation once another `MaxSize` bytes were written,
+// or `MaxAge` passed, instead of on every write.
+func (f *File) postponeRotationLocked() {
+	f.size = 0
+	f.openedAt = time.Now()
+}
+
+func (f *File) reportError(err error) {
+	if f.OnError != nil {
+		f.OnError(err)
+	}
+}
+
+// Reopen reopens the file at its path, e.g after it was moved by logrotate.
+// If that fails, it keeps writing to the current file.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fd == nil {
+		return stacktrace.NewError("%s is closed", f.path)
+	}
+
+	return f.openLocked()
+}
+
+// Close closes the file, waiting for pending compressions of rotated files.
+func (f *File) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.fd != nil {
+		err = f.fd.Close()
+		f.fd = nil
+	}
+	f.mu.Unlock()
+
+	f.compressions.Wait()
+
+	return err
+}
+
+// compress replaces the file at `path` with a gzip compressed `<path>.gz`,
+// which only appears once complete.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to open rotated %s", path)
+	}
+	defer src.Close()
+
+	tmpPath := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to create %s", tmpPath)
+	}
+
+	writer := gzip.NewWriter(dst)
+	_, err = io.Copy(writer, src)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path+".gz")
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return stacktrace.Propagate(err, "failed to compress rotated %s", path)
+	}
+
+	return os.Remove(path)
+}
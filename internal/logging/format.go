@@ -0,0 +1,200 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+)
+
+// Format is how log entries are written.
+type Format string
+
+const (
+	// FormatText is meant for humans, e.g `2006-01-02T15:04:05.000Z INFO  Opened connection relay=docker conn=1`.
+	FormatText Format = "text"
+	// FormatJSON writes a JSON object per line, e.g `{"time":"...","level":"info","msg":"...","relay":"docker"}`.
+	FormatJSON Format = "json"
+	// FormatLogfmt writes `key=value` pairs, e.g `time=... level=info msg="Opened connection" relay=docker`.
+	FormatLogfmt Format = "logfmt"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// ParseFormat parses `text`, `json` or `logfmt`.
+func ParseFormat(name string) (Format, error) {
+	format := Format(strings.ToLower(name))
+	switch format {
+	case FormatText, FormatJSON, FormatLogfmt:
+		return format, nil
+	default:
+		return "", stacktrace.NewError("invalid log format %s, expected text, json or logfmt", name)
+	}
+}
+
+// WriterSink writes formatted entries to a writer, one per line.
+type WriterSink struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format Format
+}
+
+func NewWriterSink(out io.Writer, format Format) *WriterSink {
+	return &WriterSink{
+		out:    out,
+		format: format,
+	}
+}
+
+func (s *WriterSink) SetFormat(format Format) {
+	s.mu.Lock()
+	s.format = format
+	s.mu.Unlock()
+}
+
+func (s *WriterSink) Write(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := FormatEntry(nil, entry, s.format)
+	_, err := s.out.Write(append(line, '\n'))
+
+	return err
+}
+
+// FormatEntry appends `entry` formatted as `format` to `buffer`, without a trailing newline.
+func FormatEntry(buffer []byte, entry *Entry, format Format) []byte {
+	switch format {
+	case FormatJSON:
+		return appendJSON(buffer, entry)
+	case FormatLogfmt:
+		buffer = append(buffer, "time="...)
+		buffer = entry.Time.AppendFormat(buffer, timeLayout)
+		buffer = append(buffer, " level="...)
+		buffer = append(buffer, levelName(entry.Level)...)
+		buffer = append(buffer, " msg="...)
+		buffer = appendLogfmtValue(buffer, entry.Message)
+	default:
+		buffer = entry.Time.AppendFormat(buffer, timeLayout)
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, fmt.Sprintf("%-5s", strings.ToUpper(levelName(entry.Level)))...)
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, entry.Message...)
+	}
+
+	if len(entry.Fields) > 0 {
+		buffer = append(buffer, ' ')
+		buffer = appendLogfmtFields(buffer, entry.Fields)
+	}
+
+	return buffer
+}
+
+// levelName names levels the same way as `LOG_LEVEL`, in lower case.
+func levelName(level logger.Level) string {
+	if level == logger.WarnLevel {
+		return "warn"
+	}
+
+	return level.String()
+}
+
+func appendLogfmtFields(buffer []byte, fields []Field) []byte {
+	for i, field := range fields {
+		if i > 0 {
+			buffer = append(buffer, ' ')
+		}
+
+		buffer = append(buffer, field.Key...)
+		buffer = append(buffer, '=')
+		buffer = appendLogfmtValue(buffer, fieldString(field.Value))
+	}
+
+	return buffer
+}
+
+// appendLogfmtValue quotes values that are empty or contain spaces, quotes, `=` or non-printable characters.
+func appendLogfmtValue(buffer []byte, value string) []byte {
+	needsQuoting := value == ""
+	for _, r := range value {
+		if r <= ' ' || r == '"' || r == '=' || r == '\\' || r == utf8.RuneError || r == 0x7f {
+			needsQuoting = true
+			break
+		}
+	}
+
+	if needsQuoting {
+		return strconv.AppendQuote(buffer, value)
+	}
+
+	return append(buffer, value...)
+}
+
+func fieldString(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case error:
+		return typed.Error()
+	case fmt.Stringer:
+		return typed.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func appendJSON(buffer []byte, entry *Entry) []byte {
+	buffer = append(buffer, `{"time":`...)
+	buffer = appendJSONValue(buffer, entry.Time.Format(timeLayout))
+	buffer = append(buffer, `,"level":`...)
+	buffer = appendJSONValue(buffer, levelName(entry.Level))
+	buffer = append(buffer, `,"msg":`...)
+	buffer = appendJSONValue(buffer, entry.Message)
+
+	for _, field := range entry.Fields {
+		buffer = append(buffer, ',')
+		buffer = appendJSONValue(buffer, field.Key)
+		buffer = append(buffer, ':')
+
+		switch value := field.Value.(type) {
+		case error:
+			buffer = appendJSONValue(buffer, value.Error())
+		case time.Duration:
+			buffer = appendJSONValue(buffer, value.String())
+		default:
+			buffer = appendJSONValue(buffer, value)
+		}
+	}
+
+	return append(buffer, '}')
+}
+
+func appendJSONValue(buffer []byte, value interface{}) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	return append(buffer, encoded...)
+}
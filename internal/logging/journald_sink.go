@@ -0,0 +1,109 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/palantir/stacktrace"
+)
+
+// DefaultJournaldSocket is where journald receives native protocol messages.
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldSink writes entries with their fields as journal fields, e.g `RELAY` and `CONN`,
+// using the journald native protocol.
+type JournaldSink struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func NewJournaldSink(socketPath string) (*JournaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to connect to journald at %s", socketPath)
+	}
+
+	return &JournaldSink{conn: conn}, nil
+}
+
+func (s *JournaldSink) Write(entry *Entry) error {
+	var message bytes.Buffer
+	appendJournalField(&message, "MESSAGE", entry.Message)
+	appendJournalField(&message, "PRIORITY", strconv.Itoa(syslogSeverity(entry.Level)))
+	appendJournalField(&message, "SYSLOG_IDENTIFIER", "gocat")
+
+	for _, field := range entry.Fields {
+		name := journalFieldName(field.Key)
+		if name != "" {
+			appendJournalField(&message, name, fieldString(field.Value))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// NOTE: Entries larger than a datagram would need to be passed as a memfd, which gocat doesn't do.
+	_, err := s.conn.Write(message.Bytes())
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to write to journald")
+	}
+
+	return nil
+}
+
+func (s *JournaldSink) Close() error {
+	return s.conn.Close()
+}
+
+// appendJournalField appends `KEY=value\n`, or the length prefixed binary form if `value` spans lines.
+func appendJournalField(buffer *bytes.Buffer, key, value string) {
+	buffer.WriteString(key)
+
+	if !strings.ContainsRune(value, '\n') {
+		buffer.WriteByte('=')
+		buffer.WriteString(value)
+		buffer.WriteByte('\n')
+
+		return
+	}
+
+	buffer.WriteByte('\n')
+	_ = binary.Write(buffer, binary.LittleEndian, uint64(len(value)))
+	buffer.WriteString(value)
+	buffer.WriteByte('\n')
+}
+
+// journalFieldName upper-cases `key`, replacing characters journald doesn't allow in field names with `_`.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	// NOTE: Field names starting with `_` are reserved for trusted fields set by journald.
+	return strings.TrimLeft(name, "_0123456789")
+}
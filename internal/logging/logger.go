@@ -0,0 +1,346 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging implements the `logger.Logger` of gocat with structured fields
+// and text, JSON or logfmt output.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+)
+
+// NOTE: Field names shared by every log line, so that they can be queried the same way.
+const (
+	FieldRelay       = "relay"
+	FieldConnection  = "conn"
+	FieldClient      = "client"
+	FieldDestination = "dst"
+	FieldSource      = "src"
+	FieldError       = "error"
+)
+
+var _ logger.Logger = (*Logger)(nil)
+
+// Field is a key and value pair of a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a single log line.
+type Entry struct {
+	Time    time.Time
+	Level   logger.Level
+	Message string
+	Fields  []Field
+}
+
+// Sink writes log entries.
+type Sink interface {
+	Write(entry *Entry) error
+}
+
+// Logger is a `logger.Logger` with fields added to every entry.
+// Loggers derived with `With` share their sink, and their level unless overridden with `WithLevel`.
+type Logger struct {
+	output *output
+	level  *uint32
+	fields []Field
+}
+
+// output is the sink shared by a logger and the loggers derived from it.
+type output struct {
+	mu   sync.RWMutex
+	sink Sink
+}
+
+// New creates a logger writing entries at `level` or more severe to `sink`.
+func New(sink Sink, level logger.Level) *Logger {
+	levelValue := uint32(level)
+
+	return &Logger{
+		output: &output{sink: sink},
+		level:  &levelValue,
+	}
+}
+
+// With returns a logger adding the `keyValues` pairs as fields to every entry.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]Field, len(l.fields), len(l.fields)+len(keyValues)/2)
+	copy(fields, l.fields)
+
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fields = append(fields, Field{
+			Key:   fmt.Sprint(keyValues[i]),
+			Value: keyValues[i+1],
+		})
+	}
+
+	return &Logger{
+		output: l.output,
+		level:  l.level,
+		fields: fields,
+	}
+}
+
+// WithLevel returns a logger with its own level, independent of `l`.
+func (l *Logger) WithLevel(level logger.Level) *Logger {
+	levelValue := uint32(level)
+
+	return &Logger{
+		output: l.output,
+		level:  &levelValue,
+		fields: l.fields,
+	}
+}
+
+// Sink returns where the logger writes its entries.
+func (l *Logger) Sink() Sink {
+	l.output.mu.RLock()
+	defer l.output.mu.RUnlock()
+
+	return l.output.sink
+}
+
+// SetSink replaces the sink of the logger and the loggers derived from it,
+// closing the previous sink if it's an `io.Closer`.
+func (l *Logger) SetSink(sink Sink) {
+	l.output.mu.Lock()
+	previous := l.output.sink
+	l.output.sink = sink
+	l.output.mu.Unlock()
+
+	closer, ok := previous.(io.Closer)
+	if ok {
+		_ = closer.Close()
+	}
+}
+
+func (l *Logger) SetLevel(level logger.Level) {
+	atomic.StoreUint32(l.level, uint32(level))
+}
+
+func (l *Logger) GetLevel() logger.Level {
+	return logger.Level(atomic.LoadUint32(l.level))
+}
+
+func (l *Logger) enabled(level logger.Level) bool {
+	return level <= l.GetLevel()
+}
+
+func (l *Logger) write(level logger.Level, message string) {
+	l.output.mu.RLock()
+	err := l.output.sink.Write(&Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+		Fields:  l.fields,
+	})
+	l.output.mu.RUnlock()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Could not write log entry. Error: %s\n", err)
+	}
+
+	switch level {
+	case logger.PanicLevel:
+		panic(message)
+	case logger.FatalLevel:
+		os.Exit(1)
+	}
+}
+
+func (l *Logger) Log(level logger.Level, args ...interface{}) {
+	if l.enabled(level) || level <= logger.FatalLevel {
+		l.write(level, fmt.Sprint(args...))
+	}
+}
+
+func (l *Logger) Logf(level logger.Level, format string, args ...interface{}) {
+	if l.enabled(level) || level <= logger.FatalLevel {
+		l.write(level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	}
+}
+
+func (l *Logger) Logln(level logger.Level, args ...interface{}) {
+	if l.enabled(level) || level <= logger.FatalLevel {
+		l.write(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	}
+}
+
+func (l *Logger) Debug(args ...interface{}) {
+	l.Log(logger.DebugLevel, args...)
+}
+
+func (l *Logger) Print(args ...interface{}) {
+	l.Log(logger.InfoLevel, args...)
+}
+
+func (l *Logger) Info(args ...interface{}) {
+	l.Log(logger.InfoLevel, args...)
+}
+
+func (l *Logger) Warn(args ...interface{}) {
+	l.Log(logger.WarnLevel, args...)
+}
+
+func (l *Logger) Warning(args ...interface{}) {
+	l.Log(logger.WarnLevel, args...)
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.Log(logger.ErrorLevel, args...)
+}
+
+func (l *Logger) Panic(args ...interface{}) {
+	l.Log(logger.PanicLevel, args...)
+}
+
+func (l *Logger) Fatal(args ...interface{}) {
+	l.Log(logger.FatalLevel, args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.Logf(logger.DebugLevel, format, args...)
+}
+
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.Logf(logger.InfoLevel, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.Logf(logger.InfoLevel, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Logf(logger.WarnLevel, format, args...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.Logf(logger.WarnLevel, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.Logf(logger.ErrorLevel, format, args...)
+}
+
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	l.Logf(logger.PanicLevel, format, args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.Logf(logger.FatalLevel, format, args...)
+}
+
+func (l *Logger) Debugln(args ...interface{}) {
+	l.Logln(logger.DebugLevel, args...)
+}
+
+func (l *Logger) Println(args ...interface{}) {
+	l.Logln(logger.InfoLevel, args...)
+}
+
+func (l *Logger) Infoln(args ...interface{}) {
+	l.Logln(logger.InfoLevel, args...)
+}
+
+func (l *Logger) Warnln(args ...interface{}) {
+	l.Logln(logger.WarnLevel, args...)
+}
+
+func (l *Logger) Warningln(args ...interface{}) {
+	l.Logln(logger.WarnLevel, args...)
+}
+
+func (l *Logger) Errorln(args ...interface{}) {
+	l.Logln(logger.ErrorLevel, args...)
+}
+
+func (l *Logger) Panicln(args ...interface{}) {
+	l.Logln(logger.PanicLevel, args...)
+}
+
+func (l *Logger) Fatalln(args ...interface{}) {
+	l.Logln(logger.FatalLevel, args...)
+}
+
+// With adds the `keyValues` pairs as fields to every entry of `l`.
+// Loggers other than `Logger` get the fields appended to their messages as `key=value`.
+func With(l logger.Logger, keyValues ...interface{}) logger.Logger {
+	structured, ok := l.(*Logger)
+	if !ok {
+		structured = New(&loggerSink{logger: l}, logger.DebugLevel)
+	}
+
+	return structured.With(keyValues...)
+}
+
+// WithError adds `err` as the error field to every entry of `l`.
+func WithError(l logger.Logger, err error) logger.Logger {
+	return With(l, FieldError, err)
+}
+
+// WithLevel overrides the level of `l`, returning `l` if it's not a `Logger`.
+func WithLevel(l logger.Logger, level logger.Level) logger.Logger {
+	structured, ok := l.(*Logger)
+	if !ok {
+		return l
+	}
+
+	return structured.WithLevel(level)
+}
+
+// ParseLevel parses a level name, e.g `debug` or `WARN`.
+func ParseLevel(name string) (logger.Level, error) {
+	switch strings.ToUpper(name) {
+	case "DEBUG":
+		return logger.DebugLevel, nil
+	case "INFO":
+		return logger.InfoLevel, nil
+	case "WARN", "WARNING":
+		return logger.WarnLevel, nil
+	case "ERROR":
+		return logger.ErrorLevel, nil
+	case "FATAL":
+		return logger.FatalLevel, nil
+	case "PANIC":
+		return logger.PanicLevel, nil
+	default:
+		return 0, stacktrace.NewError("invalid log level %s", name)
+	}
+}
+
+// loggerSink writes entries to a logger without fields support.
+type loggerSink struct {
+	logger logger.Logger
+}
+
+func (s *loggerSink) Write(entry *Entry) error {
+	message := entry.Message
+	if len(entry.Fields) > 0 {
+		message += " " + string(appendLogfmtFields(nil, entry.Fields))
+	}
+
+	s.logger.Log(entry.Level, message)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"io"
+
+	"github.com/palantir/stacktrace"
+)
+
+const (
+	SinkStderr   = "stderr"
+	SinkSyslog   = "syslog"
+	SinkJournald = "journald"
+)
+
+// SinkConfig selects where logs are written and how.
+type SinkConfig struct {
+	// Sink is one of `stderr`, `syslog` or `journald`.
+	Sink string
+	// Format of `stderr` logs.
+	Format Format
+	Stderr io.Writer
+	// SyslogAddress is e.g `unix:///dev/log` or `tls://host:6514`.
+	SyslogAddress  string
+	SyslogFacility string
+	// SyslogCAFile verifies TLS syslog servers instead of the system roots, if set.
+	SyslogCAFile   string
+	JournaldSocket string
+}
+
+// NewSink creates the sink selected by `config`.
+func NewSink(config SinkConfig) (Sink, error) {
+	switch config.Sink {
+	case SinkStderr, "":
+		return NewWriterSink(config.Stderr, config.Format), nil
+	case SinkSyslog:
+		return NewSyslogSink(config.SyslogAddress, config.SyslogFacility, config.SyslogCAFile)
+	case SinkJournald:
+		return NewJournaldSink(config.JournaldSocket)
+	default:
+		return nil, stacktrace.NewError("invalid log sink %s, expected stderr, syslog or journald", config.Sink)
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+)
+
+const (
+	syslogTimeLayout   = "2006-01-02T15:04:05.000000Z07:00"
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogCloseTimeout = 5 * time.Second
+	// syslogQueueSize is how many entries are buffered while syslog is slow or unreachable.
+	syslogQueueSize           = 1024
+	syslogReconnectMinBackoff = 100 * time.Millisecond
+	syslogReconnectMaxBackoff = 30 * time.Second
+	// NOTE: 32473 is the private enterprise number reserved for documentation, RFC 5612.
+	syslogStructuredDataID = "gocat@32473"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogSink writes RFC 5424 messages, with the entry fields as structured data,
+// to a local syslog socket or a remote syslog server over UDP, TCP or TLS.
+// NOTE: Entries are queued and sent in the background, so a slow or unreachable syslog
+// never blocks logging. Entries not fitting the queue, or queued while reconnecting, are dropped and counted.
+type SyslogSink struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	appName   string
+
+	queue     chan []byte
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	dropped   uint64
+
+	// NOTE: Only used by `run`, once started.
+	conn net.Conn
+	// stream is whether messages need framing, being sent over a stream connection.
+	stream           bool
+	reconnectAt      time.Time
+	reconnectBackoff time.Duration
+	reportedDropped  uint64
+}
+
+// NewSyslogSink creates a sink for `address`, one of `unix:///dev/log`, `udp://host:514`,
+// `tcp://host:601` or `tls://host:6514`. `caFile` optionally verifies TLS servers instead of the system roots.
+func NewSyslogSink(address, facility, caFile string) (*SyslogSink, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid syslog address %s", address)
+	}
+
+	facilityCode, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, stacktrace.NewError("invalid syslog facility %s", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	sink := &SyslogSink{
+		network:  parsed.Scheme,
+		address:  parsed.Host,
+		facility: facilityCode,
+		hostname: hostname,
+		appName:  "gocat",
+		queue:    make(chan []byte, syslogQueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	switch parsed.Scheme {
+	case "unix":
+		sink.address = parsed.Path
+	case "udp", "tcp":
+	case "tls":
+		sink.tlsConfig, err = syslogTLSConfig(parsed.Hostname(), caFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, stacktrace.NewError("invalid syslog address %s, expected a unix, udp, tcp or tls scheme", address)
+	}
+
+	// NOTE: Connecting once upfront fails fast on a wrong address.
+	err = sink.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	go sink.run()
+
+	return sink, nil
+}
+
+func syslogTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to read syslog CA file %s", caFile)
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, stacktrace.NewError("no certificates found in syslog CA file %s", caFile)
+	}
+
+	return config, nil
+}
+
+func (s *SyslogSink) connect() error {
+	var conn net.Conn
+	var err error
+
+	switch s.network {
+	case "unix":
+		// NOTE: Local syslog daemons listen on datagram sockets, except for some listening on stream ones.
+		conn, err = net.DialTimeout("unixgram", s.address, syslogDialTimeout)
+		s.stream = false
+		if err != nil {
+			conn, err = net.DialTimeout("unix", s.address, syslogDialTimeout)
+			s.stream = true
+		}
+	case "tls":
+		dialer := &net.Dialer{Timeout: syslogDialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+		s.stream = true
+	default:
+		conn, err = net.DialTimeout(s.network, s.address, syslogDialTimeout)
+		s.stream = s.network == "tcp"
+	}
+
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to connect to syslog at %s://%s", s.network, s.address)
+	}
+
+	s.conn = conn
+
+	return nil
+}
+
+// Write queues `entry`, dropping it if the queue is full.
+func (s *SyslogSink) Write(entry *Entry) error {
+	select {
+	case <-s.closing:
+		return stacktrace.NewError("syslog sink is closed")
+	default:
+	}
+
+	select {
+	case s.queue <- s.format(entry):
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+
+	return nil
+}
+
+// Dropped returns how many entries were dropped so far.
+func (s *SyslogSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// run sends the queued messages until the sink is closed, then the ones still queued.
+func (s *SyslogSink) run() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			_ = s.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case message := <-s.queue:
+			s.send(message)
+		case <-s.closing:
+			for {
+				select {
+				case message := <-s.queue:
+					if !s.send(message) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes `message`, reconnecting once if that fails, e.g after the syslog daemon restarted.
+// Reconnecting is backed off after failures, dropping messages meanwhile.
+func (s *SyslogSink) send(message []byte) bool {
+	if s.conn != nil && s.write(message) == nil {
+		s.reportDropped()
+		return true
+	}
+
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+
+	if time.Now().Before(s.reconnectAt) {
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+
+	err := s.connect()
+	if err == nil {
+		err = s.write(message)
+	}
+
+	if err != nil {
+		if s.conn != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+		}
+
+		s.reconnectBackoff = nextSyslogReconnectBackoff(s.reconnectBackoff)
+		s.reconnectAt = time.Now().Add(s.reconnectBackoff)
+		atomic.AddUint64(&s.dropped, 1)
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"Could not write to syslog, retrying in %s. Error: %s\n",
+			s.reconnectBackoff,
+			err,
+		)
+
+		return false
+	}
+
+	s.reconnectBackoff = 0
+	s.reportDropped()
+
+	return true
+}
+
+// reportDropped logs how many entries were dropped since the last report, if any.
+func (s *SyslogSink) reportDropped() {
+	dropped := atomic.LoadUint64(&s.dropped)
+	if dropped == s.reportedDropped {
+		return
+	}
+
+	message := s.format(&Entry{
+		Time:    time.Now(),
+		Level:   logger.WarnLevel,
+		Message: fmt.Sprintf("Dropped %d log entries, syslog was slow or unreachable", dropped-s.reportedDropped),
+	})
+	if s.write(message) == nil {
+		s.reportedDropped = dropped
+	}
+}
+
+func (s *SyslogSink) write(message []byte) error {
+	if s.stream {
+		if s.network == "unix" {
+			message = append(message, '\n')
+		} else {
+			// NOTE: Octet counting framing of RFC 6587 and RFC 5425.
+			message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+		}
+	}
+
+	err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to set syslog write deadline")
+	}
+
+	_, err = s.conn.Write(message)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to write to syslog at %s://%s", s.network, s.address)
+	}
+
+	return nil
+}
+
+func nextSyslogReconnectBackoff(backoff time.Duration) time.Duration {
+	if backoff < syslogReconnectMinBackoff {
+		return syslogReconnectMinBackoff
+	}
+
+	backoff *= 2
+	if backoff > syslogReconnectMaxBackoff {
+		return syslogReconnectMaxBackoff
+	}
+
+	return backoff
+}
+
+// Close sends the entries still queued, for up to a few seconds, and disconnects.
+func (s *SyslogSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(syslogCloseTimeout):
+		return stacktrace.NewError("timed out sending queued entries to syslog")
+	}
+}
+
+// format formats `entry` as `<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID key="value"...] MSG`.
+func (s *SyslogSink) format(entry *Entry) []byte {
+	buffer := []byte(fmt.Sprintf(
+		"<%d>1 %s %s %s %d - ",
+		s.facility*8+syslogSeverity(entry.Level),
+		entry.Time.Format(syslogTimeLayout),
+		s.hostname,
+		s.appName,
+		os.Getpid(),
+	))
+
+	if len(entry.Fields) == 0 {
+		buffer = append(buffer, '-')
+	} else {
+		buffer = append(buffer, '[')
+		buffer = append(buffer, syslogStructuredDataID...)
+		for _, field := range entry.Fields {
+			buffer = append(buffer, ' ')
+			buffer = append(buffer, syslogParamName(field.Key)...)
+			buffer = append(buffer, `="`...)
+			buffer = append(buffer, syslogParamValue(fieldString(field.Value))...)
+			buffer = append(buffer, '"')
+		}
+		buffer = append(buffer, ']')
+	}
+
+	buffer = append(buffer, ' ')
+
+	return append(buffer, entry.Message...)
+}
+
+func syslogSeverity(level logger.Level) int {
+	switch level {
+	case logger.PanicLevel, logger.FatalLevel:
+		return 2
+	case logger.ErrorLevel:
+		return 3
+	case logger.WarnLevel:
+		return 4
+	case logger.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// syslogParamName keeps the printable ASCII characters allowed in SD-NAMEs, up to 32.
+func syslogParamName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+
+		return r
+	}, key)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	return name
+}
+
+// syslogParamValue escapes `"`, `\` and `]` as required in PARAM-VALUEs.
+func syslogParamValue(value string) string {
+	var builder strings.Builder
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			builder.WriteByte('\\')
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+)
+
+// Tag is a dimension of a metric, e.g the relay name.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+func NewTag(key, value string) Tag {
+	return Tag{Key: key, Value: value}
+}
+
+// Recorder receives the metrics emitted by relays.
+// Implementations must be safe for concurrent use.
+type Recorder interface {
+	IncrCounter(name string, value int64, tags ...Tag)
+	SetGauge(name string, value float64, tags ...Tag)
+	ObserveDuration(name string, value time.Duration, tags ...Tag)
+}
+
+// Nop discards all metrics.
+type Nop struct{}
+
+func (Nop) IncrCounter(string, int64, ...Tag) {}
+
+func (Nop) SetGauge(string, float64, ...Tag) {}
+
+func (Nop) ObserveDuration(string, time.Duration, ...Tag) {}
+
+// Multi reports metrics to all of its recorders, e.g to both Prometheus and StatsD.
+type Multi []Recorder
+
+func (m Multi) IncrCounter(name string, value int64, tags ...Tag) {
+	for _, recorder := range m {
+		recorder.IncrCounter(name, value, tags...)
+	}
+}
+
+func (m Multi) SetGauge(name string, value float64, tags ...Tag) {
+	for _, recorder := range m {
+		recorder.SetGauge(name, value, tags...)
+	}
+}
+
+func (m Multi) ObserveDuration(name string, value time.Duration, tags ...Tag) {
+	for _, recorder := range m {
+		recorder.ObserveDuration(name, value, tags...)
+	}
+}
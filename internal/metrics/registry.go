@@ -0,0 +1,180 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prometheusPrefix = "gocat_"
+
+type seriesKind int
+
+const (
+	kindCounter seriesKind = iota
+	kindGauge
+	kindDuration
+)
+
+type series struct {
+	name  string
+	kind  seriesKind
+	tags  []Tag
+	value float64
+	count int64
+}
+
+// Registry keeps the latest value of every metric in-memory
+// and exposes them in the Prometheus text format.
+type Registry struct {
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+var _ Recorder = (*Registry)(nil)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		series: make(map[string]*series),
+	}
+}
+
+func (r *Registry) IncrCounter(name string, value int64, tags ...Tag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lookup(name, kindCounter, tags).value += float64(value)
+}
+
+func (r *Registry) SetGauge(name string, value float64, tags ...Tag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lookup(name, kindGauge, tags).value = value
+}
+
+func (r *Registry) ObserveDuration(name string, value time.Duration, tags ...Tag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(name, kindDuration, tags)
+	s.value += value.Seconds()
+	s.count++
+}
+
+func (r *Registry) lookup(name string, kind seriesKind, tags []Tag) *series {
+	key := name + formatLabels(tags)
+
+	s, ok := r.series[key]
+	if !ok {
+		s = &series{
+			name: name,
+			kind: kind,
+			tags: append([]Tag(nil), tags...),
+		}
+		r.series[key] = s
+	}
+
+	return s
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	all := make([]series, 0, len(r.series))
+	for _, s := range r.series {
+		all = append(all, *s)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+
+		return formatLabels(all[i].tags) < formatLabels(all[j].tags)
+	})
+
+	var lastName string
+	for _, s := range all {
+		name := prometheusPrefix + s.name
+		if s.name != lastName {
+			lastName = s.name
+
+			_, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, prometheusType(s.kind))
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch s.kind {
+		case kindDuration:
+			_, err = fmt.Fprintf(
+				w,
+				"%s_sum%s %g\n%s_count%s %d\n",
+				name,
+				formatLabels(s.tags),
+				s.value,
+				name,
+				formatLabels(s.tags),
+				s.count,
+			)
+		default:
+			_, err = fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(s.tags), s.value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = r.WritePrometheus(w)
+}
+
+func prometheusType(kind seriesKind) string {
+	switch kind {
+	case kindCounter:
+		return "counter"
+	case kindGauge:
+		return "gauge"
+	default:
+		return "summary"
+	}
+}
+
+func formatLabels(tags []Tag) string {
+	if len(tags) < 1 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		labels = append(labels, fmt.Sprintf("%s=%q", tag.Key, tag.Value))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
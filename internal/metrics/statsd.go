@@ -0,0 +1,356 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+)
+
+const (
+	// FlavorStatsD appends the tag values to metric names, e.g `gocat.connections_accepted_total.my-relay`.
+	FlavorStatsD = "statsd"
+	// FlavorDogStatsD sends tags in the DogStatsD format, e.g `gocat.connections_accepted_total:1|c|#relay:my-relay`.
+	FlavorDogStatsD = "dogstatsd"
+
+	defaultStatsDFlushInterval = time.Second
+	// NOTE: A slow agent gets metrics dropped rather than delaying the following packets.
+	statsDWriteTimeout = 100 * time.Millisecond
+	// statsDQueueSize is how many full packets wait to be sent before further ones are dropped.
+	statsDQueueSize = 64
+
+	// NOTE: Keeps UDP packets within the usual Ethernet MTU to avoid fragmentation.
+	maxUDPPacketSize      = 1432
+	maxUnixgramPacketSize = 8192
+)
+
+// StatsDConfig configures where and how metrics are pushed.
+type StatsDConfig struct {
+	// Address of the agent, e.g `udp://127.0.0.1:8125` or `unixgram:///var/run/datadog/dsd.socket`.
+	Address string
+	// Prefix is prepended to metric names, separated by a dot. No prefix if empty.
+	Prefix string
+	// Flavor is either FlavorStatsD or FlavorDogStatsD. Defaults to FlavorDogStatsD.
+	Flavor string
+	// FlushInterval is how often buffered metrics are sent, if they don't fill a packet.
+	FlushInterval time.Duration
+}
+
+// StatsD pushes metrics to a StatsD or DogStatsD agent over UDP or a Unix datagram socket,
+// buffering them into packets sent in the background. Metrics that can't be sent are dropped,
+// e.g while the agent isn't listening yet.
+type StatsD struct {
+	network       string
+	address       string
+	prefix        string
+	flavor        string
+	flushInterval time.Duration
+	maxPacketSize int
+	logger        logger.Logger
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+	// packets are full packets to be sent by `run`.
+	packets chan []byte
+
+	// NOTE: Only used by `run`, connected on first use.
+	conn net.Conn
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Recorder = (*StatsD)(nil)
+
+func NewStatsD(config StatsDConfig, logger logger.Logger) (*StatsD, error) {
+	parsed, err := url.Parse(config.Address)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid StatsD address %s", config.Address)
+	}
+
+	s := &StatsD{
+		network:       parsed.Scheme,
+		prefix:        config.Prefix,
+		flavor:        config.Flavor,
+		flushInterval: config.FlushInterval,
+		logger:        logger,
+		packets:       make(chan []byte, statsDQueueSize),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	switch parsed.Scheme {
+	case "udp":
+		s.address = parsed.Host
+		s.maxPacketSize = maxUDPPacketSize
+	case "unixgram":
+		s.address = parsed.Path
+		s.maxPacketSize = maxUnixgramPacketSize
+	default:
+		return nil, stacktrace.NewError(
+			"invalid StatsD address %s, expected udp://host:port or unixgram:///path",
+			config.Address,
+		)
+	}
+
+	if s.address == "" {
+		return nil, stacktrace.NewError("invalid StatsD address %s, missing host or path", config.Address)
+	}
+
+	switch s.flavor {
+	case "":
+		s.flavor = FlavorDogStatsD
+	case FlavorStatsD, FlavorDogStatsD:
+	default:
+		return nil, stacktrace.NewError(
+			"invalid StatsD flavor %s, expected %s or %s",
+			config.Flavor,
+			FlavorStatsD,
+			FlavorDogStatsD,
+		)
+	}
+
+	if s.flushInterval <= 0 {
+		s.flushInterval = defaultStatsDFlushInterval
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+func (s *StatsD) IncrCounter(name string, value int64, tags ...Tag) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (s *StatsD) SetGauge(name string, value float64, tags ...Tag) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *StatsD) ObserveDuration(name string, value time.Duration, tags ...Tag) {
+	milliseconds := float64(value) / float64(time.Millisecond)
+	s.send(name, strconv.FormatFloat(milliseconds, 'f', -1, 64), "ms", tags)
+}
+
+// Close sends the buffered metrics and stops pushing.
+func (s *StatsD) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	<-s.stopped
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+func (s *StatsD) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			for {
+				select {
+				case packet := <-s.packets:
+					s.write(packet)
+				default:
+					s.write(s.takeBuffered())
+					return
+				}
+			}
+		case packet := <-s.packets:
+			s.write(packet)
+		case <-ticker.C:
+			s.write(s.takeBuffered())
+		}
+	}
+}
+
+func (s *StatsD) send(name, value, kind string, tags []Tag) {
+	line := s.format(name, value, kind, tags)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buffer.Len() > 0 && s.buffer.Len()+1+len(line) > s.maxPacketSize {
+		select {
+		case s.packets <- s.takeBufferedLocked():
+		default:
+			s.logger.Debugf("Dropping metrics, too many are waiting to be sent to StatsD at %s", s.address)
+		}
+	}
+
+	if s.buffer.Len() > 0 {
+		s.buffer.WriteByte('\n')
+	}
+
+	s.buffer.WriteString(line)
+}
+
+func (s *StatsD) format(name, value, kind string, tags []Tag) string {
+	var line strings.Builder
+
+	if s.prefix != "" {
+		line.WriteString(s.prefix)
+		line.WriteByte('.')
+	}
+
+	line.WriteString(statsDName(name))
+
+	if s.flavor == FlavorStatsD {
+		for _, tag := range tags {
+			line.WriteByte('.')
+			line.WriteString(statsDName(tag.Value))
+		}
+	}
+
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(kind)
+
+	if s.flavor == FlavorDogStatsD && len(tags) > 0 {
+		line.WriteString("|#")
+		for i, tag := range tags {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+
+			line.WriteString(statsDTag(tag.Key))
+			line.WriteByte(':')
+			line.WriteString(statsDTag(tag.Value))
+		}
+	}
+
+	return line.String()
+}
+
+// takeBuffered returns a copy of the buffered metrics, emptying the buffer.
+func (s *StatsD) takeBuffered() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.takeBufferedLocked()
+}
+
+func (s *StatsD) takeBufferedLocked() []byte {
+	if s.buffer.Len() == 0 {
+		return nil
+	}
+
+	packet := append([]byte(nil), s.buffer.Bytes()...)
+	s.buffer.Reset()
+
+	return packet
+}
+
+// write sends `packet`, if not empty, logging why it was dropped otherwise.
+func (s *StatsD) write(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	err := s.writePacket(packet)
+	if err != nil {
+		logging.WithError(s.logger, err).Debugf("Dropping metrics, could not send them to StatsD at %s", s.address)
+	}
+}
+
+// writePacket sends `packet`, reconnecting once if the agent went away, e.g restarted and re-created its socket.
+func (s *StatsD) writePacket(packet []byte) error {
+	if s.conn != nil {
+		err := s.writeConn(packet)
+		if err == nil {
+			return nil
+		}
+
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	return s.writeConn(packet)
+}
+
+func (s *StatsD) writeConn(packet []byte) error {
+	err := s.conn.SetWriteDeadline(time.Now().Add(statsDWriteTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(packet)
+
+	return err
+}
+
+func (s *StatsD) connect() error {
+	conn, err := net.Dial(s.network, s.address)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to connect to StatsD at %s", s.address)
+	}
+
+	s.conn = conn
+
+	return nil
+}
+
+// statsDName replaces the characters not allowed in metric names,
+// including dots which would add levels to the metric's path.
+func statsDName(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\t', '\n':
+			return '_'
+		default:
+			return r
+		}
+	}, value)
+}
+
+// statsDTag replaces the characters not allowed in DogStatsD tags.
+func statsDTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\t', '\n':
+			return '_'
+		default:
+			return r
+		}
+	}, value)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux multiplexes many logical streams over a single connection
+// between two gocat instances, with per-stream flow control.
+//
+// Every frame starts with a 12 bytes header:
+//
+//	| version (1) | type (1) | reserved (2) | stream ID (4) | length (4) |
+//
+// followed by `length` bytes of payload for data frames.
+// For window update frames `length` is the receive window credit granted to the peer.
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/palantir/stacktrace"
+)
+
+const (
+	protocolVersion = 1
+	headerSize      = 12
+
+	// initialWindow is the number of bytes a peer may send on a stream before
+	// it has to wait for a window update.
+	initialWindow = 256 * 1024
+	// maxFrameSize is the largest payload of a single data frame.
+	maxFrameSize = 32 * 1024
+)
+
+type frameType uint8
+
+const (
+	frameOpen frameType = iota + 1
+	frameData
+	frameWindowUpdate
+	frameClose
+	frameReset
+)
+
+var (
+	ErrSessionClosed = stacktrace.NewError("mux session is closed")
+	ErrStreamClosed  = stacktrace.NewError("mux stream is closed")
+	ErrStreamReset   = stacktrace.NewError("mux stream was reset by peer")
+	errProtocol      = stacktrace.NewError("mux protocol violation")
+)
+
+type header struct {
+	frameType frameType
+	streamID  uint32
+	length    uint32
+}
+
+func (h header) encode(b []byte) {
+	b[0] = protocolVersion
+	b[1] = byte(h.frameType)
+	b[2] = 0
+	b[3] = 0
+	binary.BigEndian.PutUint32(b[4:8], h.streamID)
+	binary.BigEndian.PutUint32(b[8:12], h.length)
+}
+
+func readHeader(r io.Reader, b []byte) (header, error) {
+	_, err := io.ReadFull(r, b[:headerSize])
+	if err != nil {
+		return header{}, err
+	}
+
+	if b[0] != protocolVersion {
+		return header{}, errProtocol
+	}
+
+	return header{
+		frameType: frameType(b[1]),
+		streamID:  binary.BigEndian.Uint32(b[4:8]),
+		length:    binary.BigEndian.Uint32(b[8:12]),
+	}, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/palantir/stacktrace"
+)
+
+const acceptBacklog = 256
+
+// Session multiplexes streams over a single connection.
+// It implements `net.Listener`, accepting the streams opened by the peer.
+type Session struct {
+	conn   net.Conn
+	nextID uint32
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+
+	writeMu sync.Mutex
+
+	// NOTE: Control frames sent in reaction to received frames are queued for `controlLoop`,
+	// as `recvLoop` writing them could deadlock with a peer that isn't reading while it writes.
+	controlMu     sync.Mutex
+	control       []header
+	controlNotify chan struct{}
+
+	acceptCh  chan *Stream
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Client starts a session on the dialing side of `conn`.
+func Client(conn net.Conn) *Session {
+	return newSession(conn, 1)
+}
+
+// Server starts a session on the accepting side of `conn`.
+func Server(conn net.Conn) *Session {
+	return newSession(conn, 2)
+}
+
+func newSession(conn net.Conn, firstID uint32) *Session {
+	s := &Session{
+		conn:          conn,
+		nextID:        firstID,
+		streams:       make(map[uint32]*Stream),
+		controlNotify: make(chan struct{}, 1),
+		acceptCh:      make(chan *Stream, acceptBacklog),
+		closed:        make(chan struct{}),
+	}
+
+	go s.recvLoop()
+	go s.controlLoop()
+
+	return s
+}
+
+// Open opens a new stream to the peer.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.IsClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	err := s.writeFrame(header{frameType: frameOpen, streamID: id}, nil)
+	if err != nil {
+		s.removeStream(id)
+		return nil, stacktrace.Propagate(err, "failed to open mux stream")
+	}
+
+	return stream, nil
+}
+
+// Accept waits for the next stream opened by the peer.
+func (s *Session) Accept() (net.Conn, error) {
+	select {
+	case stream := <-s.acceptCh:
+		return stream, nil
+	case <-s.closed:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Close closes the session, the underlying connection and all of its streams.
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Done is closed once the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// Err returns why the session was closed.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeErr
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams)
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closeErr = err
+		close(s.closed)
+		s.mu.Unlock()
+
+		_ = s.conn.Close()
+	})
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.streams, id)
+}
+
+func (s *Session) writeFrame(h header, payload []byte) error {
+	frame := make([]byte, headerSize+len(payload))
+	h.encode(frame)
+	copy(frame[headerSize:], payload)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	_, err := s.conn.Write(frame)
+	if err != nil {
+		s.closeWithError(err)
+		return err
+	}
+
+	return nil
+}
+
+// queueControl queues a control frame to be written by `controlLoop`, without blocking.
+func (s *Session) queueControl(h header) {
+	s.controlMu.Lock()
+	s.control = append(s.control, h)
+	s.controlMu.Unlock()
+
+	select {
+	case s.controlNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) controlLoop() {
+	for {
+		select {
+		case <-s.controlNotify:
+		case <-s.closed:
+			return
+		}
+
+		s.controlMu.Lock()
+		pending := s.control
+		s.control = nil
+		s.controlMu.Unlock()
+
+		for _, h := range pending {
+			err := s.writeFrame(h, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) recvLoop() {
+	headerBuffer := make([]byte, headerSize)
+	payloadBuffer := make([]byte, maxFrameSize)
+
+	for {
+		h, err := readHeader(s.conn, headerBuffer)
+		if err != nil {
+			if err == io.EOF {
+				err = ErrSessionClosed
+			}
+
+			s.closeWithError(err)
+			return
+		}
+
+		err = s.handleFrame(h, payloadBuffer)
+		if err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(h header, payloadBuffer []byte) error {
+	s.mu.Lock()
+	stream := s.streams[h.streamID]
+	s.mu.Unlock()
+
+	switch h.frameType {
+	case frameOpen:
+		if stream != nil {
+			return stacktrace.Propagate(errProtocol, "stream %d opened twice", h.streamID)
+		}
+
+		stream = newStream(s, h.streamID)
+
+		s.mu.Lock()
+		s.streams[h.streamID] = stream
+		s.mu.Unlock()
+
+		select {
+		case s.acceptCh <- stream:
+		default:
+			stream.abort()
+			s.queueControl(header{frameType: frameReset, streamID: h.streamID})
+		}
+	case frameData:
+		if h.length > maxFrameSize {
+			return stacktrace.Propagate(errProtocol, "data frame of %d bytes exceeds maximum", h.length)
+		}
+
+		payload := payloadBuffer[:h.length]
+		_, err := io.ReadFull(s.conn, payload)
+		if err != nil {
+			return err
+		}
+
+		// NOTE: Data might still be in-flight for streams closed locally.
+		if stream == nil {
+			return nil
+		}
+
+		return stream.receive(payload)
+	case frameWindowUpdate:
+		if stream != nil {
+			stream.grantSendWindow(h.length)
+		}
+	case frameClose:
+		if stream != nil {
+			stream.remoteClose()
+		}
+	case frameReset:
+		if stream != nil {
+			stream.abort()
+		}
+	default:
+		return stacktrace.Propagate(errProtocol, "unknown frame type %d", h.frameType)
+	}
+
+	return nil
+}
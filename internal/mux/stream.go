@@ -0,0 +1,353 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// Stream is a logical connection within a session. It implements `net.Conn`.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mu            sync.Mutex
+	recvBuffer    bytes.Buffer
+	recvWindow    uint32
+	recvConsumed  uint32
+	sendWindow    uint32
+	readDeadline  time.Time
+	writeDeadline time.Time
+	localClosed   bool
+	remoteClosed  bool
+	reset         bool
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+}
+
+var _ net.Conn = (*Stream)(nil)
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:          id,
+		session:     session,
+		recvWindow:  initialWindow,
+		sendWindow:  initialWindow,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+func (st *Stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.recvBuffer.Len() > 0 {
+			n, _ := st.recvBuffer.Read(b)
+			update := st.consume(uint32(n))
+			st.mu.Unlock()
+
+			if update > 0 {
+				st.session.queueControl(header{frameType: frameWindowUpdate, streamID: st.id, length: update})
+			}
+
+			return n, nil
+		}
+
+		err := st.readErrLocked()
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+
+		err = st.wait(st.readNotify, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// consume accounts read bytes and returns the window credit to grant to the peer, if any.
+func (st *Stream) consume(n uint32) uint32 {
+	st.recvConsumed += n
+	if st.recvConsumed < initialWindow/2 {
+		return 0
+	}
+
+	update := st.recvConsumed
+	st.recvConsumed = 0
+	st.recvWindow += update
+	return update
+}
+
+func (st *Stream) readErrLocked() error {
+	switch {
+	case st.reset:
+		return ErrStreamReset
+	case st.localClosed:
+		return ErrStreamClosed
+	case st.remoteClosed:
+		return io.EOF
+	case st.session.IsClosed():
+		return ErrSessionClosed
+	default:
+		return nil
+	}
+}
+
+func (st *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		st.mu.Lock()
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return written, ErrStreamReset
+		case st.localClosed:
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+
+			err := st.wait(st.writeNotify, deadline)
+			if err != nil {
+				return written, err
+			}
+
+			continue
+		}
+
+		n := uint32(len(b) - written)
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		chunk := b[written : written+int(n)]
+		err := st.session.writeFrame(header{frameType: frameData, streamID: st.id, length: n}, chunk)
+		if err != nil {
+			return written, err
+		}
+
+		written += int(n)
+	}
+
+	return written, nil
+}
+
+// Close closes the stream in both directions and tells the peer it won't send more data.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+
+	st.localClosed = true
+	wasReset := st.reset
+	alreadyDone := st.remoteClosed || wasReset
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+	st.notify(st.writeNotify)
+
+	if alreadyDone {
+		st.session.removeStream(st.id)
+	}
+
+	if wasReset {
+		return nil
+	}
+
+	err := st.session.writeFrame(header{frameType: frameClose, streamID: st.id}, nil)
+	if err != nil && err != ErrSessionClosed {
+		return stacktrace.Propagate(err, "failed to close mux stream %d", st.id)
+	}
+
+	return nil
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return streamAddr{addr: st.session.conn.LocalAddr(), id: st.id}
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return streamAddr{addr: st.session.conn.RemoteAddr(), id: st.id}
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.writeDeadline = t
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+	st.notify(st.writeNotify)
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+
+	st.notify(st.writeNotify)
+	return nil
+}
+
+func (st *Stream) receive(payload []byte) error {
+	st.mu.Lock()
+	if uint32(len(payload)) > st.recvWindow {
+		st.mu.Unlock()
+		return stacktrace.Propagate(errProtocol, "peer exceeded receive window of stream %d", st.id)
+	}
+
+	st.recvWindow -= uint32(len(payload))
+
+	var update uint32
+	if st.localClosed {
+		// NOTE: Nobody is going to read it, but the peer must not get stuck on its send window.
+		update = st.consume(uint32(len(payload)))
+	} else {
+		st.recvBuffer.Write(payload)
+	}
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+
+	if update > 0 {
+		st.session.queueControl(header{frameType: frameWindowUpdate, streamID: st.id, length: update})
+	}
+
+	return nil
+}
+
+func (st *Stream) grantSendWindow(n uint32) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.mu.Unlock()
+
+	st.notify(st.writeNotify)
+}
+
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	done := st.localClosed
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+	if done {
+		st.session.removeStream(st.id)
+	}
+}
+
+func (st *Stream) abort() {
+	st.mu.Lock()
+	st.reset = true
+	st.mu.Unlock()
+
+	st.notify(st.readNotify)
+	st.notify(st.writeNotify)
+	st.session.removeStream(st.id)
+}
+
+func (st *Stream) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until notified, the deadline passes or the session closes.
+func (st *Stream) wait(notify <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		delay := time.Until(deadline)
+		if delay <= 0 {
+			return errTimeout
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-notify:
+		return nil
+	case <-timeout:
+		return errTimeout
+	case <-st.session.closed:
+		return ErrSessionClosed
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout on mux stream" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout net.Error = timeoutError{}
+
+type streamAddr struct {
+	addr net.Addr
+	id   uint32
+}
+
+func (a streamAddr) Network() string {
+	return "mux"
+}
+
+func (a streamAddr) String() string {
+	return fmt.Sprintf("%s#%d", a.addr, a.id)
+}
+
+// Parent returns the address of the connection the stream is multiplexed over.
+func (a streamAddr) Parent() net.Addr {
+	return a.addr
+}
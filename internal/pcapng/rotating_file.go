@@ -0,0 +1,122 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapng
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const rotatingFileTimeFormat = "20060102T150405.000000"
+
+// RotatingFile writes packets to `<prefix>-<timestamp>.pcapng` files,
+// starting a new file once the current one exceeds a size or age.
+type RotatingFile struct {
+	prefix  string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu       sync.Mutex
+	fd       *os.File
+	writer   *Writer
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingFile creates files lazily, on the first written packet.
+// `maxSize` and `maxAge` of 0 never rotate.
+func NewRotatingFile(prefix string, maxSize int64, maxAge time.Duration) *RotatingFile {
+	return &RotatingFile{
+		prefix:  prefix,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+}
+
+func (f *RotatingFile) WritePacket(ts time.Time, packet []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fd != nil && f.expiredLocked(ts) {
+		_ = f.closeLocked()
+	}
+
+	if f.fd == nil {
+		err := f.openLocked(ts)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := f.writer.WritePacket(ts, packet)
+	f.size += int64(n)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to write packet to %s", f.fd.Name())
+	}
+
+	return nil
+}
+
+func (f *RotatingFile) expiredLocked(ts time.Time) bool {
+	if f.maxSize > 0 && f.size >= f.maxSize {
+		return true
+	}
+
+	return f.maxAge > 0 && ts.Sub(f.openedAt) >= f.maxAge
+}
+
+func (f *RotatingFile) openLocked(ts time.Time) error {
+	path := fmt.Sprintf("%s-%s.pcapng", f.prefix, ts.UTC().Format(rotatingFileTimeFormat))
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to create capture file %s", path)
+	}
+
+	writer, err := NewWriter(fd)
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+
+	f.fd = fd
+	f.writer = writer
+	f.size = sectionHeaderBlockLength + interfaceBlockLength
+	f.openedAt = ts
+
+	return nil
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.closeLocked()
+}
+
+func (f *RotatingFile) closeLocked() error {
+	if f.fd == nil {
+		return nil
+	}
+
+	err := f.fd.Close()
+	f.fd = nil
+	f.writer = nil
+
+	return err
+}
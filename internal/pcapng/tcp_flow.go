@@ -0,0 +1,207 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapng
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+
+	ipv4HeaderLength = 20
+	ipv6HeaderLength = 40
+	tcpHeaderLength  = 20
+	// maxSegmentSize keeps synthesized IPv4 packets within their 16 bits total length.
+	maxSegmentSize = 65000
+)
+
+// TCPFlow synthesizes the packets of a TCP connection between `client` and `server`,
+// tracking sequence numbers so Wireshark can follow the stream.
+// It's not safe for concurrent use.
+type TCPFlow struct {
+	client    *net.TCPAddr
+	server    *net.TCPAddr
+	ipv6      bool
+	clientSeq uint32
+	serverSeq uint32
+}
+
+func NewTCPFlow(client, server *net.TCPAddr) *TCPFlow {
+	return &TCPFlow{
+		client: client,
+		server: server,
+		ipv6:   client.IP.To4() == nil || server.IP.To4() == nil,
+	}
+}
+
+// Open returns the three-way handshake.
+func (f *TCPFlow) Open() [][]byte {
+	packets := [][]byte{
+		f.segment(true, tcpFlagSYN, nil),
+	}
+	f.clientSeq++
+
+	packets = append(packets, f.segment(false, tcpFlagSYN|tcpFlagACK, nil))
+	f.serverSeq++
+
+	return append(packets, f.segment(true, tcpFlagACK, nil))
+}
+
+// Data returns the segments carrying `payload` sent by the client, or by the server if not `fromClient`.
+func (f *TCPFlow) Data(fromClient bool, payload []byte) [][]byte {
+	var packets [][]byte
+	for len(payload) > 0 {
+		size := len(payload)
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+
+		packets = append(packets, f.segment(fromClient, tcpFlagPSH|tcpFlagACK, payload[:size]))
+		if fromClient {
+			f.clientSeq += uint32(size)
+		} else {
+			f.serverSeq += uint32(size)
+		}
+
+		payload = payload[size:]
+	}
+
+	return packets
+}
+
+// Close returns the connection teardown, initiated by the client.
+func (f *TCPFlow) Close() [][]byte {
+	packets := [][]byte{
+		f.segment(true, tcpFlagFIN|tcpFlagACK, nil),
+	}
+	f.clientSeq++
+
+	packets = append(packets, f.segment(false, tcpFlagFIN|tcpFlagACK, nil))
+	f.serverSeq++
+
+	return append(packets, f.segment(true, tcpFlagACK, nil))
+}
+
+func (f *TCPFlow) segment(fromClient bool, flags byte, payload []byte) []byte {
+	src, dst := f.client, f.server
+	seq, ack := f.clientSeq, f.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+
+	if flags&tcpFlagACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, tcpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = (tcpHeaderLength / 4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF)
+	copy(tcp[tcpHeaderLength:], payload)
+
+	if f.ipv6 {
+		return f.ipv6Packet(src.IP.To16(), dst.IP.To16(), tcp)
+	}
+
+	return f.ipv4Packet(src.IP.To4(), dst.IP.To4(), tcp)
+}
+
+func (f *TCPFlow) ipv4Packet(src, dst net.IP, tcp []byte) []byte {
+	packet := make([]byte, ipv4HeaderLength+len(tcp))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	// NOTE: Don't fragment.
+	binary.BigEndian.PutUint16(packet[6:], 0x4000)
+	packet[8] = 64
+	packet[9] = 6
+	copy(packet[12:16], src)
+	copy(packet[16:20], dst)
+	binary.BigEndian.PutUint16(packet[10:], checksum(0, packet[:ipv4HeaderLength]))
+
+	copy(packet[ipv4HeaderLength:], tcp)
+	setTCPChecksum(packet[ipv4HeaderLength:], src, dst)
+
+	return packet
+}
+
+func (f *TCPFlow) ipv6Packet(src, dst net.IP, tcp []byte) []byte {
+	packet := make([]byte, ipv6HeaderLength+len(tcp))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(tcp)))
+	packet[6] = 6
+	packet[7] = 64
+	copy(packet[8:24], src)
+	copy(packet[24:40], dst)
+
+	copy(packet[ipv6HeaderLength:], tcp)
+	setTCPChecksum(packet[ipv6HeaderLength:], src, dst)
+
+	return packet
+}
+
+// setTCPChecksum computes the checksum of `tcp` including the pseudo header of `src` and `dst`.
+func setTCPChecksum(tcp []byte, src, dst net.IP) {
+	pseudo := make([]byte, 0, 2*len(src)+8)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, 6)
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(tcp)))
+	if len(src) == net.IPv4len {
+		pseudo = append(pseudo, length[2:]...)
+	} else {
+		pseudo = append(pseudo, length[:]...)
+	}
+
+	sum := sumWords(0, pseudo)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(sum, tcp))
+}
+
+func checksum(initial uint32, data []byte) uint16 {
+	sum := sumWords(initial, data)
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+
+	return ^uint16(sum)
+}
+
+func sumWords(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	// NOTE: Fold to avoid overflows on large data.
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+
+	return sum
+}
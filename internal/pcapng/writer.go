@@ -0,0 +1,90 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pcapng writes raw IP packets to pcapng files readable by Wireshark.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const (
+	blockTypeSectionHeader    = 0x0A0D0D0A
+	blockTypeInterface        = 0x00000001
+	blockTypeEnhancedPacket   = 0x00000006
+	byteOrderMagic            = 0x1A2B3C4D
+	linkTypeRaw               = 101
+	sectionHeaderBlockLength  = 28
+	interfaceBlockLength      = 20
+	enhancedPacketBlockLength = 32
+)
+
+// Writer writes packets of a single raw IP (`LINKTYPE_RAW`) interface,
+// timestamped in microseconds.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the section header and interface description to `w`.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, sectionHeaderBlockLength+interfaceBlockLength)
+
+	binary.LittleEndian.PutUint32(header[0:], blockTypeSectionHeader)
+	binary.LittleEndian.PutUint32(header[4:], sectionHeaderBlockLength)
+	binary.LittleEndian.PutUint32(header[8:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(header[12:], 1)
+	binary.LittleEndian.PutUint16(header[14:], 0)
+	// NOTE: Unspecified section length.
+	binary.LittleEndian.PutUint64(header[16:], 0xFFFFFFFFFFFFFFFF)
+	binary.LittleEndian.PutUint32(header[24:], sectionHeaderBlockLength)
+
+	idb := header[sectionHeaderBlockLength:]
+	binary.LittleEndian.PutUint32(idb[0:], blockTypeInterface)
+	binary.LittleEndian.PutUint32(idb[4:], interfaceBlockLength)
+	binary.LittleEndian.PutUint16(idb[8:], linkTypeRaw)
+	binary.LittleEndian.PutUint16(idb[10:], 0)
+	binary.LittleEndian.PutUint32(idb[12:], 0)
+	binary.LittleEndian.PutUint32(idb[16:], interfaceBlockLength)
+
+	_, err := w.Write(header)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to write pcapng header")
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes an IP `packet` captured at `ts`, returning the written bytes.
+func (w *Writer) WritePacket(ts time.Time, packet []byte) (int, error) {
+	padding := (4 - len(packet)%4) % 4
+	length := enhancedPacketBlockLength + len(packet) + padding
+	block := make([]byte, length)
+
+	micros := uint64(ts.UnixNano() / int64(time.Microsecond))
+	binary.LittleEndian.PutUint32(block[0:], blockTypeEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:], uint32(length))
+	binary.LittleEndian.PutUint32(block[8:], 0)
+	binary.LittleEndian.PutUint32(block[12:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(block[16:], uint32(micros))
+	binary.LittleEndian.PutUint32(block[20:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(block[24:], uint32(len(packet)))
+	copy(block[28:], packet)
+	binary.LittleEndian.PutUint32(block[length-4:], uint32(length))
+
+	return w.w.Write(block)
+}
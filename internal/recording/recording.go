@@ -0,0 +1,153 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recording stores relayed sessions as JSON lines of timestamped events and replays them.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+type EventType string
+
+const (
+	EventOpen EventType = "open"
+	// EventClientData is data sent by the client to the source.
+	EventClientData EventType = "client_data"
+	// EventSourceData is data sent by the source back to the client.
+	EventSourceData EventType = "source_data"
+	EventClose      EventType = "close"
+)
+
+// Event is a single line of a recording.
+type Event struct {
+	// Run identifies the gocat process that recorded the event,
+	// since connection IDs restart with every process appending to the same file.
+	Run        string    `json:"run,omitempty"`
+	Connection uint64    `json:"conn"`
+	Time       time.Time `json:"time"`
+	Type       EventType `json:"type"`
+	Client     string    `json:"client,omitempty"`
+	Data       []byte    `json:"data,omitempty"`
+}
+
+// Writer appends events to a recording. It's safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{encoder: json.NewEncoder(w)}
+}
+
+func (w *Writer) Write(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.encoder.Encode(event)
+}
+
+// Session is the recorded events of a single connection.
+type Session struct {
+	Run        string
+	Connection uint64
+	Client     string
+	Events     []Event
+}
+
+// Start is the time of the first event of the session.
+func (s *Session) Start() time.Time {
+	if len(s.Events) < 1 {
+		return time.Time{}
+	}
+
+	return s.Events[0].Time
+}
+
+// String identifies the session in logs, e.g `run=20261019T153614.123456789Z-4242 conn=3`.
+func (s *Session) String() string {
+	return describeSession(s.Run, s.Connection)
+}
+
+func describeSession(run string, connection uint64) string {
+	// NOTE: Recordings of older versions have no runs.
+	if run == "" {
+		return fmt.Sprintf("conn=%d", connection)
+	}
+
+	return fmt.Sprintf("run=%s conn=%d", run, connection)
+}
+
+type sessionKey struct {
+	run        string
+	connection uint64
+}
+
+// ReadSessions reads a recording, returning its sessions ordered by start.
+// Sessions are told apart by their run and connection ID.
+func ReadSessions(r io.Reader) ([]*Session, error) {
+	byConnection := make(map[sessionKey]*Session)
+
+	scanner := bufio.NewScanner(r)
+	// NOTE: Lines carry base64 encoded chunks of up to the relay buffer size.
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		var event Event
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "line %d: invalid event", lineNumber)
+		}
+
+		key := sessionKey{run: event.Run, connection: event.Connection}
+		session, ok := byConnection[key]
+		if !ok {
+			session = &Session{Run: event.Run, Connection: event.Connection}
+			byConnection[key] = session
+		}
+
+		if event.Type == EventOpen {
+			session.Client = event.Client
+		}
+
+		session.Events = append(session.Events, event)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to read recording")
+	}
+
+	sessions := make([]*Session, 0, len(byConnection))
+	for _, session := range byConnection {
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Start().Before(sessions[j].Start())
+	})
+
+	return sessions, nil
+}
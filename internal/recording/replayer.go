@@ -0,0 +1,223 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recording
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const divergenceContextBytes = 32
+
+// Divergence is where the responses of a replayed session differ from the recording.
+type Divergence struct {
+	Run        string
+	Connection uint64
+	// Offset is the position in the source data stream of the session.
+	Offset   int
+	Expected []byte
+	Actual   []byte
+	// After describes the last client data sent before the divergence.
+	After string
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf(
+		"%s responses diverge at byte %d %s. Expected %q, got %q",
+		describeSession(d.Run, d.Connection),
+		d.Offset,
+		d.After,
+		d.Expected,
+		d.Actual,
+	)
+}
+
+// Replayer plays the client side of recorded sessions against a target,
+// comparing the target responses with the recorded source data.
+type Replayer struct {
+	// Dial connects to the target.
+	Dial func(ctx context.Context) (net.Conn, error)
+	// Speed scales the recorded timing, e.g 2 replays twice as fast. As fast as possible if 0.
+	Speed float64
+	// ResponseTimeout is how long to wait for the recorded responses before sending the next client data.
+	ResponseTimeout time.Duration
+}
+
+// Replay plays `session`, returning the first divergence of its responses, if any.
+func (r *Replayer) Replay(ctx context.Context, session *Session) (*Divergence, error) {
+	conn, err := r.Dial(ctx)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to dial target for %s", session)
+	}
+	defer conn.Close()
+
+	received := newResponseBuffer()
+	go received.readFrom(conn)
+
+	start := time.Now()
+	var expected []byte
+	after := "before any client data"
+	sentChunks := 0
+	for _, event := range session.Events {
+		switch event.Type {
+		case EventSourceData:
+			expected = append(expected, event.Data...)
+		case EventClientData:
+			divergence := r.compare(session, received, expected, after, false)
+			if divergence != nil {
+				return divergence, nil
+			}
+
+			err = r.sleepUntil(ctx, start, event.Time.Sub(session.Start()))
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = conn.Write(event.Data)
+			if err != nil {
+				return nil, stacktrace.Propagate(err, "failed to send client data of %s", session)
+			}
+
+			sentChunks++
+			after = fmt.Sprintf("after client chunk %d at +%s", sentChunks, event.Time.Sub(session.Start()))
+		}
+	}
+
+	return r.compare(session, received, expected, after, true), nil
+}
+
+// compare waits for `expected` to be received, returning the divergence if it isn't.
+// Unless `final`, the target may have sent more than expected so far.
+func (r *Replayer) compare(
+	session *Session,
+	received *responseBuffer,
+	expected []byte,
+	after string,
+	final bool,
+) *Divergence {
+	actual := received.waitFor(len(expected), r.ResponseTimeout)
+
+	offset := 0
+	for offset < len(expected) && offset < len(actual) && expected[offset] == actual[offset] {
+		offset++
+	}
+
+	if offset == len(expected) && (!final || len(actual) == len(expected)) {
+		return nil
+	}
+
+	return &Divergence{
+		Run:        session.Run,
+		Connection: session.Connection,
+		Offset:     offset,
+		Expected:   excerpt(expected, offset),
+		Actual:     excerpt(actual, offset),
+		After:      after,
+	}
+}
+
+func excerpt(data []byte, offset int) []byte {
+	if offset >= len(data) {
+		return nil
+	}
+
+	end := offset + divergenceContextBytes
+	if end > len(data) {
+		end = len(data)
+	}
+
+	return data[offset:end]
+}
+
+func (r *Replayer) sleepUntil(ctx context.Context, start time.Time, offset time.Duration) error {
+	if r.Speed <= 0 {
+		return nil
+	}
+
+	wait := time.Until(start.Add(time.Duration(float64(offset) / r.Speed)))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// responseBuffer accumulates everything the target sent.
+type responseBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newResponseBuffer() *responseBuffer {
+	b := &responseBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+func (b *responseBuffer) readFrom(conn net.Conn) {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buffer)
+
+		b.mu.Lock()
+		b.data = append(b.data, buffer[:n]...)
+		if err != nil {
+			b.closed = true
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitFor waits up to `timeout` for `size` bytes, returning what was received so far.
+func (b *responseBuffer) waitFor(size int, timeout time.Duration) []byte {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		expired = true
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.data) < size && !b.closed && !expired {
+		b.cond.Wait()
+	}
+
+	return append([]byte(nil), b.data...)
+}
@@ -19,21 +19,89 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/palantir/stacktrace"
 	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/metrics"
+	"github.com/sumup-oss/gocat/internal/tracing"
 )
 
+var errPacketTruncated = stacktrace.NewError("seqpacket packet larger than the buffer size")
+
 type AbstractDuplexRelay struct {
+	// NOTE: First to be 64-bit aligned for atomic operations on 32-bit platforms.
+	connectionCount     uint64
+	name                string
+	metrics             metrics.Recorder
+	breaker             *circuitBreaker
+	pool                *sourcePool
+	sourceResolver      *tcpSourceResolver
+	limiter             *connectionLimiter
+	rateLimiter         *connectionRateLimiter
+	shaper              *BandwidthShaper
+	clientOpening       ClientOpening
+	dumper              *TrafficDumper
+	capturer            *Capturer
+	sessionRecorder     *SessionRecorder
+	accessLog           *AccessLog
+	tracer              *tracing.Exporter
+	ipFilter            *IPFilter
+	peerAuthorizer      *PeerAuthorizer
 	healthCheckInterval time.Duration
 	logger              logger.Logger
 	sourceName          string
 	destinationName     string
 	destinationAddr     string
 	bufferSize          int
-	dialSourceConn      func(context.Context) (net.Conn, error)
-	listenTargetConn    func(context.Context) (net.Listener, error)
+	frameSource         bool
+	frameDestination    bool
+	// NOTE: Seqpacket sides fail on packets not fitting `bufferSize`.
+	seqPacketSource      bool
+	seqPacketDestination bool
+	dialSourceConn       func(context.Context) (net.Conn, error)
+	listenTargetConn     func(context.Context) (net.Listener, error)
+	sessions             *sessionRegistry
+	pause                *acceptPause
+	healthCheckRequests  chan chan error
+	// NOTE: Closed once `healthCheckSource` started and stopped serving `healthCheckRequests`.
+	healthCheckStarted chan struct{}
+	healthCheckStopped chan struct{}
+}
+
+func (r *AbstractDuplexRelay) configure(o *options, defaultName string) {
+	r.name = o.name
+	if r.name == "" {
+		r.name = defaultName
+	}
+
+	r.logger = logging.With(r.logger, logging.FieldRelay, r.name)
+	if o.logLevel != nil {
+		r.logger = logging.WithLevel(r.logger, *o.logLevel)
+	}
+
+	r.metrics = o.metrics
+	r.breaker = newCircuitBreaker(o.circuitBreaker, r.logger, r.metrics, r.name)
+	r.ipFilter = o.ipFilter
+	r.peerAuthorizer = o.peerAuthorizer
+	r.shaper = o.bandwidthShaper
+	r.clientOpening = o.clientOpening
+	r.dumper = o.trafficDumper
+	r.capturer = o.capturer
+	r.sessionRecorder = o.sessionRecorder
+	r.accessLog = o.accessLog
+	r.tracer = o.tracer
+	r.rateLimiter = newConnectionRateLimiter(o.rateLimits, r.logger, r.metrics, r.name)
+	r.limiter = newConnectionLimiter(o.connectionLimits, r.metrics, r.name)
+	r.pool = newSourcePool(r.dialSource, o.sourcePoolSize, o.sourcePoolMaxIdle, r.logger, r.metrics, r.name)
+	r.sessions = newSessionRegistry()
+	r.pause = &acceptPause{}
+	r.healthCheckRequests = make(chan chan error)
+	r.healthCheckStarted = make(chan struct{})
+	r.healthCheckStopped = make(chan struct{})
 }
 
 func (r *AbstractDuplexRelay) Relay(ctx context.Context) error {
@@ -45,6 +113,12 @@ func (r *AbstractDuplexRelay) Relay(ctx context.Context) error {
 
 	ctx, cancel := context.WithCancel(ctx)
 	go r.healthCheckSource(ctx, cancel)
+	if r.pool != nil {
+		go r.pool.run(ctx)
+	}
+	if r.sourceResolver != nil {
+		go r.sourceResolver.run(ctx)
+	}
 	go func() {
 		<-ctx.Done()
 		listener.Close()
@@ -61,77 +135,306 @@ func (r *AbstractDuplexRelay) Relay(ctx context.Context) error {
 			continue
 		}
 
-		r.logger.Infof("Established connection to %s", conn.RemoteAddr())
-		go r.handleConnection(ctx, conn)
+		// NOTE: While paused, clients connecting after this one wait in the listen backlog.
+		if !r.pause.wait(ctx) {
+			_ = conn.Close()
+			return nil
+		}
+
+		credentials, err := unixPeerCredentials(conn)
+		if err != nil {
+			logging.WithError(r.logger, err).Warnf("Could not read peer credentials of %s", r.destinationName)
+		}
+
+		if !r.admit(conn, credentials) {
+			_ = conn.Close()
+			continue
+		}
+
+		id := atomic.AddUint64(&r.connectionCount, 1)
+		client := describeClient(conn, credentials)
+		session := newConnectionSession(ctx, id, client, conn, r.logger)
+		r.sessions.add(session)
+		r.metrics.IncrCounter("connections_accepted_total", 1, metrics.NewTag("relay", r.name))
+		session.logger.Infof("Accepted %s", r.destinationName)
+		go r.handleLimitedConnection(ctx, session, conn, credentials)
+	}
+}
+
+// handleLimitedConnection handles `conn` once it fits in the connection limits.
+func (r *AbstractDuplexRelay) handleLimitedConnection(
+	ctx context.Context,
+	session *connectionSession,
+	conn net.Conn,
+	credentials *PeerCredentials,
+) {
+	if r.limiter == nil {
+		r.handleConnection(ctx, session, conn)
+		return
+	}
+
+	limitKey := connectionLimitKey(conn, credentials)
+	reason, ok := r.limiter.acquire(ctx, limitKey)
+	if !ok {
+		r.reject(session.logger, reason)
+		_ = conn.Close()
+		session.setCloseReason(reason)
+		r.closeSession(session)
+		return
+	}
+	defer r.limiter.release(limitKey)
+
+	r.handleConnection(ctx, session, conn)
+}
+
+// admit decides whether an accepted connection is relayed.
+// `credentials` are nil unless it's a unix socket connection.
+func (r *AbstractDuplexRelay) admit(conn net.Conn, credentials *PeerCredentials) bool {
+	if r.ipFilter != nil {
+		ip := remoteIP(conn.RemoteAddr())
+		if ip != nil && !r.ipFilter.Allowed(ip) {
+			r.reject(r.clientLogger(conn, credentials), "ip_denied")
+			return false
+		}
+	}
+
+	if r.peerAuthorizer != nil {
+		if credentials == nil || !r.peerAuthorizer.Allowed(credentials) {
+			r.reject(r.clientLogger(conn, credentials), "peer_denied")
+			return false
+		}
+	}
+
+	if r.rateLimiter != nil {
+		reason, ok := r.rateLimiter.allow(connectionLimitKey(conn, credentials))
+		if !ok {
+			r.reject(r.clientLogger(conn, credentials), reason)
+			return false
+		}
 	}
+
+	return true
+}
+
+// describeClient identifies the client of an accepted connection in logs,
+// by the peer credentials for unix sockets and the remote address otherwise.
+func describeClient(conn net.Conn, credentials *PeerCredentials) string {
+	if credentials != nil {
+		return credentials.String()
+	}
+
+	return conn.RemoteAddr().String()
+}
+
+// clientLogger adds the client of a connection rejected before it got an ID to the relay logger.
+func (r *AbstractDuplexRelay) clientLogger(conn net.Conn, credentials *PeerCredentials) logger.Logger {
+	return logging.With(r.logger, logging.FieldClient, describeClient(conn, credentials))
+}
+
+// reject logs and counts a rejected connection, `log` being the logger of its client.
+func (r *AbstractDuplexRelay) reject(log logger.Logger, reason string) {
+	logging.With(log, "reason", reason).Warnf("Rejected connection from %s", r.destinationName)
+	r.metrics.IncrCounter(
+		"connections_rejected_total",
+		1,
+		metrics.NewTag("relay", r.name),
+		metrics.NewTag("reason", reason),
+	)
 }
 
 func (r *AbstractDuplexRelay) healthCheckSource(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
 
+	close(r.healthCheckStarted)
+	defer close(r.healthCheckStopped)
+
 	ticker := time.NewTicker(r.healthCheckInterval)
 	defer ticker.Stop()
 
-	// NOTE: Dial source to make sure it's alive
-	conn, err := r.dialSourceConn(ctx)
-	if err != nil {
-		r.logger.Errorf(
-			"Could not dial %s for health check. Error: %s\n",
-			r.sourceName,
-			err,
-		)
+	if r.checkSourceHealth(ctx) != nil {
 		return
 	}
-	conn.Close()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// NOTE: Dial source to make sure it's alive
-			conn, err := r.dialSourceConn(ctx)
-			if err != nil {
-				r.logger.Errorf(
-					"Could not dial %s for health check. Error: %s\n",
-					r.sourceName,
-					err,
-				)
-				conn.Close()
+			if r.checkSourceHealth(ctx) != nil {
 				return
 			}
-			conn.Close()
+		case resultCh := <-r.healthCheckRequests:
+			resultCh <- r.checkSourceHealth(ctx)
+		}
+	}
+}
+
+// checkSourceHealth dials the source to make sure it's alive and reports the outcome.
+func (r *AbstractDuplexRelay) checkSourceHealth(ctx context.Context) error {
+	conn, err := r.dialSourceConn(ctx)
+	if err != nil {
+		// NOTE: Stopping the relay cancels the dial, that's not a failed health check.
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+
+		r.metrics.IncrCounter(
+			"health_checks_total",
+			1,
+			metrics.NewTag("relay", r.name),
+			metrics.NewTag("result", "failure"),
+		)
+		logging.WithError(r.logger, err).Errorf("Could not dial %s for health check", r.sourceName)
+
+		return err
 	}
+
+	_ = conn.Close()
+	r.metrics.IncrCounter(
+		"health_checks_total",
+		1,
+		metrics.NewTag("relay", r.name),
+		metrics.NewTag("result", "success"),
+	)
+
+	return nil
+}
+
+// dialSource dials the source for a relayed connection, guarded by the circuit breaker if any.
+func (r *AbstractDuplexRelay) dialSource(ctx context.Context) (net.Conn, error) {
+	if r.breaker != nil {
+		err := r.breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := r.dialSourceConn(ctx)
+	if err != nil {
+		r.metrics.IncrCounter("source_dial_failures_total", 1, metrics.NewTag("relay", r.name))
+	} else if r.seqPacketSource {
+		// NOTE: Wrapped before pooling, `newSeqPacketConn` can't see through pooled connections.
+		conn = newSeqPacketConn(conn)
+	}
+
+	if r.breaker != nil {
+		// NOTE: Cancelled dials say nothing about the health of the source,
+		// but must not keep the half-open breaker waiting for their outcome.
+		if ctx.Err() != nil {
+			r.breaker.cancelProbe()
+		} else {
+			r.breaker.record(err)
+		}
+	}
+
+	return conn, err
+}
+
+// acquireSource returns a pre-dialed source connection if pooling is enabled,
+// otherwise dials the source.
+func (r *AbstractDuplexRelay) acquireSource(ctx context.Context) (net.Conn, error) {
+	if r.pool != nil {
+		return r.pool.get(ctx)
+	}
+
+	return r.dialSource(ctx)
 }
 
 // nolint:funlen
-func (r *AbstractDuplexRelay) handleConnection(ctx context.Context, conn net.Conn) {
+func (r *AbstractDuplexRelay) handleConnection(ctx context.Context, session *connectionSession, conn net.Conn) {
+	id := session.id
+	client := session.client
+	log := session.logger
+
 	defer func(conn net.Conn) {
 		_ = conn.Close()
-		logger.Infof("Closed connection to %s %s", r.destinationName, conn.RemoteAddr())
+		r.closeSession(session)
 	}(conn)
 
+	if r.frameDestination {
+		conn = newLengthPrefixedConn(conn)
+	}
+
+	if r.seqPacketDestination {
+		conn = newSeqPacketConn(conn)
+	}
+
+	if r.clientOpening.defersDial() {
+		openedConn, reason, err := r.awaitClientOpening(conn)
+		if err != nil {
+			if reason != "" {
+				r.reject(log, reason)
+				session.setCloseReason(reason)
+				return
+			}
+
+			logging.WithError(log, err).Debugf("Could not read from %s", r.destinationName)
+			session.setCloseReason(closeReason(err, "client_closed", "client_error"))
+			return
+		}
+
+		session.markFirstByte()
+
+		conn = openedConn
+	} else if r.clientOpening.FirstByteTimeout > 0 {
+		conn = newFirstByteConn(conn, r.clientOpening.FirstByteTimeout)
+	}
+
 	// NOTE: Accepted connection at `dst` address
 	// must be using read/write deadlines to make sure
 	// we're not leaking goroutines by waiting on half-closed connections.
 	destDeadlineConn := NewDeadlineConnection(conn, writeDeadlineTimeout, readDeadlineTimeout)
 
-	r.logger.Infof("Handling connection from %s %s", r.destinationName, destDeadlineConn.remoteAddress)
+	sourceDialStarted := time.Now()
+	sourceConn, err := r.acquireSource(ctx)
+	if err == errCircuitOpen {
+		r.reject(log, "circuit_open")
+		session.setCloseReason("circuit_open")
+		return
+	}
 
-	sourceConn, err := r.dialSourceConn(ctx)
 	if err != nil {
-		r.logger.Errorf(
-			"Could not read from source %s. Error: %s",
-			r.sourceName,
-			err,
-		)
+		logging.WithError(log, err).Errorf("Could not read from source %s", r.sourceName)
+		session.setSourceDialed(sourceDialStarted, "")
+		session.setCloseReason("source_dial_failed")
 		return
 	}
 
+	session.setSourceDialed(sourceDialStarted, sourceConn.RemoteAddr().String())
+	session.logOpen()
+
+	if r.frameSource {
+		sourceConn = newLengthPrefixedConn(sourceConn)
+	}
+
 	defer sourceConn.Close()
 	defer destDeadlineConn.Close()
 
+	var shaper *connectionShaper
+	if r.shaper != nil {
+		shaper = r.shaper.connection(session.ctx)
+	}
+
+	var dump *connectionDump
+	if r.dumper != nil {
+		dump = r.dumper.connection(id, conn, client)
+	}
+
+	var capture *connectionCapture
+	if r.capturer != nil {
+		capture = r.capturer.connection(id, conn, sourceConn)
+	}
+
+	if capture != nil {
+		defer capture.close()
+	}
+
+	var record *sessionRecording
+	if r.sessionRecorder != nil {
+		record = r.sessionRecorder.connection(id, client)
+		defer record.close()
+	}
+
 	var wg sync.WaitGroup
 
 	wg.Add(1)
@@ -144,26 +447,25 @@ func (r *AbstractDuplexRelay) handleConnection(ctx context.Context, conn net.Con
 		for {
 			readBytes, err := sourceConn.Read(buffer)
 			if err != nil {
+				session.cancel()
+
+				if err == errPacketTruncated {
+					logging.WithError(log, err).Errorf("Could not relay packet of %s", r.sourceName)
+					session.setCloseReason("packet_truncated")
+				}
+
+				session.setCloseReason(closeReason(err, "source_closed", "source_error"))
 				sourceConn.Close()
 				// NOTE: Force close destination connection to stop
 				// the "destination read to source write" goroutine.
 				destDeadlineConn.Close()
 
 				if err == io.EOF {
-					r.logger.Debugf(
-						"Reached EOF of %s %s. Stopping reading",
-						r.sourceName,
-						sourceConn.RemoteAddr(),
-					)
+					log.Debugf("Reached EOF of %s. Stopping reading", r.sourceName)
 					return
 				}
 
-				r.logger.Debugf(
-					"Could not read from %s %s. Error: %s\n",
-					r.sourceName,
-					sourceConn.RemoteAddr(),
-					err,
-				)
+				logging.WithError(log, err).Debugf("Could not read from %s", r.sourceName)
 				return
 			}
 
@@ -171,36 +473,62 @@ func (r *AbstractDuplexRelay) handleConnection(ctx context.Context, conn net.Con
 				continue
 			}
 
+			if dump != nil {
+				dump.dump('<', buffer[:readBytes])
+			}
+
+			if capture != nil {
+				capture.fromSource(buffer[:readBytes])
+			}
+
+			if record != nil {
+				record.sourceData(buffer[:readBytes])
+			}
+
+			if shaper != nil && shaper.waitDownload(readBytes) != nil {
+				// NOTE: Closed meanwhile, e.g killed.
+				sourceConn.Close()
+				destDeadlineConn.Close()
+				return
+			}
+
 			// NOTE: Pad to the read bytes to remove 0s
-			_, _ = destDeadlineConn.Write(buffer[:readBytes])
+			writtenBytes, _ := destDeadlineConn.Write(buffer[:readBytes])
+			session.addBytesOut(writtenBytes)
 		}
 	}()
 
 	// NOTE: Read from destination and write to source
 	buffer := make([]byte, r.bufferSize)
+	receivedFirstByte := false
 	for {
 		readBytes, err := destDeadlineConn.Read(buffer)
 		if err != nil {
+			session.cancel()
+
+			if err == errFirstByteTimeout {
+				r.reject(log, "first_byte_timeout")
+				session.setCloseReason("first_byte_timeout")
+			}
+
+			if err == errPacketTruncated {
+				logging.WithError(log, err).Errorf("Could not relay packet of %s", r.destinationName)
+				session.setCloseReason("packet_truncated")
+			}
+
+			session.setCloseReason(closeReason(err, "client_closed", "client_error"))
+
 			destDeadlineConn.Close()
 			// NOTE: Force close source connection to stop
 			// the "source read to dest write" goroutine.
 			sourceConn.Close()
 
 			if err == io.EOF {
-				r.logger.Debugf(
-					"Reached EOF of %s %s. Stopping reading",
-					r.destinationName,
-					destDeadlineConn.remoteAddress,
-				)
+				log.Debugf("Reached EOF of %s. Stopping reading", r.destinationName)
 				break
 			}
 
-			r.logger.Debugf(
-				"Could not read from %s %s. Error: %s",
-				r.destinationName,
-				destDeadlineConn.remoteAddress,
-				err,
-			)
+			logging.WithError(log, err).Debugf("Could not read from %s", r.destinationName)
 			break
 		}
 
@@ -208,18 +536,70 @@ func (r *AbstractDuplexRelay) handleConnection(ctx context.Context, conn net.Con
 			continue
 		}
 
+		if !receivedFirstByte {
+			receivedFirstByte = true
+			session.markFirstByte()
+		}
+
+		if dump != nil {
+			dump.dump('>', buffer[:readBytes])
+		}
+
+		if capture != nil {
+			capture.fromClient(buffer[:readBytes])
+		}
+
+		if record != nil {
+			record.clientData(buffer[:readBytes])
+		}
+
+		if shaper != nil && shaper.waitUpload(readBytes) != nil {
+			// NOTE: Closed meanwhile, e.g killed.
+			destDeadlineConn.Close()
+			sourceConn.Close()
+			break
+		}
+
 		// NOTE: Pad to the read bytes to remove 0s
-		_, err = sourceConn.Write(buffer[:readBytes])
+		writtenBytes, err := sourceConn.Write(buffer[:readBytes])
+		session.addBytesIn(writtenBytes)
 		if err != nil {
-			r.logger.Errorf(
-				"Could not write to %s %s. Error: %s",
-				r.sourceName,
-				sourceConn.RemoteAddr(),
-				err,
-			)
+			session.setCloseReason("source_error")
+			logging.WithError(log, err).Errorf("Could not write to %s", r.sourceName)
 			return
 		}
 	}
 
 	wg.Wait()
 }
+
+// closeSession forgets a finished connection, logs its close event and access log line,
+// and reports its metrics.
+func (r *AbstractDuplexRelay) closeSession(session *connectionSession) {
+	session.cancel()
+	r.sessions.remove(session)
+	session.logClose()
+	session.recordMetrics(r.metrics, r.name)
+
+	if r.tracer != nil {
+		r.tracer.Export(session.span(r.name))
+	}
+
+	if r.accessLog == nil {
+		return
+	}
+
+	err := r.accessLog.Write(session.accessLogEntry(r.name))
+	if err != nil {
+		logging.WithError(session.logger, err).Errorf("Could not write access log")
+	}
+}
+
+// closeReason tells apart a side of the connection closing from failing.
+func closeReason(err error, closed, failed string) string {
+	if err == io.EOF {
+		return closed
+	}
+
+	return failed
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// DefaultAccessLogTemplate is the line template of access logs if none is specified.
+const DefaultAccessLogTemplate = `{{.Start.UTC.Format "2006-01-02T15:04:05.000Z07:00"}} ` +
+	`relay={{.Relay}} conn={{.Connection}} client={{.Client}} dst={{.Destination}} src={{.Source}} ` +
+	`bytes_in={{.BytesIn}} bytes_out={{.BytesOut}} duration={{.Duration}} reason={{.Reason}}`
+
+// AccessLogEntry is a finished connection, as available to access log line templates.
+type AccessLogEntry struct {
+	Relay       string
+	Connection  uint64
+	Client      string
+	Destination string
+	Source      string
+	Start       time.Time
+	End         time.Time
+	Duration    time.Duration
+	BytesIn     uint64
+	BytesOut    uint64
+	Reason      string
+}
+
+// AccessLog writes a line per finished connection, apart from the operational logs.
+type AccessLog struct {
+	template *template.Template
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewAccessLog writes lines formatted by `lineTemplate`, a `text/template` of `AccessLogEntry`, to `writer`.
+func NewAccessLog(writer io.Writer, lineTemplate string) (*AccessLog, error) {
+	if lineTemplate == "" {
+		lineTemplate = DefaultAccessLogTemplate
+	}
+
+	parsed, err := template.New("access-log").Parse(lineTemplate)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid access log template")
+	}
+
+	return &AccessLog{
+		template: parsed,
+		writer:   writer,
+	}, nil
+}
+
+// Write writes the line of `entry`.
+func (a *AccessLog) Write(entry *AccessLogEntry) error {
+	var line bytes.Buffer
+	err := a.template.Execute(&line, entry)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to format access log line")
+	}
+
+	line.WriteByte('\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err = a.writer.Write(line.Bytes())
+
+	return err
+}
+
+func (s *connectionSession) accessLogEntry(relayName string) *AccessLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := time.Now()
+
+	return &AccessLogEntry{
+		Relay:       relayName,
+		Connection:  s.id,
+		Client:      s.client,
+		Destination: s.destinationAddress,
+		Source:      s.sourceAddress,
+		Start:       s.accepted,
+		End:         end,
+		Duration:    end.Sub(s.accepted),
+		BytesIn:     atomic.LoadUint64(&s.bytesIn),
+		BytesOut:    atomic.LoadUint64(&s.bytesOut),
+		Reason:      s.closeReasonLocked(),
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// BandwidthLimits are in bytes per second. Limits of 0 are unlimited.
+// Upload is data sent by clients to the source, download is data sent by the source to clients.
+type BandwidthLimits struct {
+	// Upload and Download are shared by all connections of a relay.
+	Upload   int64
+	Download int64
+	// ConnectionUpload and ConnectionDownload apply to each connection.
+	ConnectionUpload   int64
+	ConnectionDownload int64
+}
+
+// BandwidthShaper throttles data relayed by connections.
+// Its limits can be changed at runtime, applying to already established connections as well.
+type BandwidthShaper struct {
+	path         string
+	staticLimits BandwidthLimits
+
+	mu       sync.RWMutex
+	limits   BandwidthLimits
+	upload   *bandwidthLimiter
+	download *bandwidthLimiter
+}
+
+// NewBandwidthShaper creates a shaper out of `limits`, overridden by the limits in the file at `path`, if not empty.
+//
+// Every non-empty line of the file that isn't a `#` comment is `<limit> <rate>`,
+// limit being one of upload, download, connection-upload or connection-download.
+// Rates are bytes per second with an optional K, M or G (binary) suffix, e.g 512K.
+func NewBandwidthShaper(limits BandwidthLimits, path string) (*BandwidthShaper, error) {
+	s := &BandwidthShaper{
+		path:         path,
+		staticLimits: limits,
+	}
+	s.upload = newBandwidthLimiter(func() int64 { return s.Limits().Upload })
+	s.download = newBandwidthLimiter(func() int64 { return s.Limits().Download })
+
+	err := s.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload re-reads the limits file, keeping the previous limits on failure.
+func (s *BandwidthShaper) Reload() error {
+	limits := s.staticLimits
+	if s.path != "" {
+		err := readBandwidthFile(s.path, &limits)
+		if err != nil {
+			return stacktrace.Propagate(err, "could not load bandwidth limits file %s", s.path)
+		}
+	}
+
+	s.SetLimits(limits)
+	return nil
+}
+
+// SetLimits replaces the limits of the shaper.
+func (s *BandwidthShaper) SetLimits(limits BandwidthLimits) {
+	s.mu.Lock()
+	s.limits = limits
+	s.mu.Unlock()
+}
+
+func (s *BandwidthShaper) Limits() BandwidthLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.limits
+}
+
+// connection throttles a connection until `ctx` is done.
+func (s *BandwidthShaper) connection(ctx context.Context) *connectionShaper {
+	return &connectionShaper{
+		ctx:      ctx,
+		shaper:   s,
+		upload:   newBandwidthLimiter(func() int64 { return s.Limits().ConnectionUpload }),
+		download: newBandwidthLimiter(func() int64 { return s.Limits().ConnectionDownload }),
+	}
+}
+
+// connectionShaper throttles a single connection by both its own and its relay's limits.
+type connectionShaper struct {
+	ctx      context.Context
+	shaper   *BandwidthShaper
+	upload   *bandwidthLimiter
+	download *bandwidthLimiter
+}
+
+func (c *connectionShaper) waitUpload(n int) error {
+	err := c.upload.wait(c.ctx, n)
+	if err != nil {
+		return err
+	}
+
+	return c.shaper.upload.wait(c.ctx, n)
+}
+
+func (c *connectionShaper) waitDownload(n int) error {
+	err := c.download.wait(c.ctx, n)
+	if err != nil {
+		return err
+	}
+
+	return c.shaper.download.wait(c.ctx, n)
+}
+
+// bandwidthLimiter is a token bucket of bytes, holding up to a second worth of its rate.
+// Waiting reserves bytes upfront, so concurrent waiters are served in order.
+type bandwidthLimiter struct {
+	rate func() int64
+
+	mu       sync.Mutex
+	tokens   float64
+	lastFill time.Time
+}
+
+func newBandwidthLimiter(rate func() int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:     rate,
+		lastFill: time.Now(),
+	}
+}
+
+// wait blocks until `n` bytes may be relayed, or `ctx` is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	rate := l.rate()
+
+	l.mu.Lock()
+	now := time.Now()
+	if rate <= 0 {
+		l.tokens = 0
+		l.lastFill = now
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.tokens += now.Sub(l.lastFill).Seconds() * float64(rate)
+	l.tokens = math.Min(l.tokens, float64(rate))
+	l.lastFill = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / float64(rate) * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func readBandwidthFile(path string, limits *BandwidthLimits) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return stacktrace.NewError("line %d: expected `<limit> <rate>`", lineNumber)
+		}
+
+		rate, err := ParseByteSize(fields[1])
+		if err != nil {
+			return stacktrace.Propagate(err, "line %d", lineNumber)
+		}
+
+		switch fields[0] {
+		case "upload":
+			limits.Upload = rate
+		case "download":
+			limits.Download = rate
+		case "connection-upload":
+			limits.ConnectionUpload = rate
+		case "connection-download":
+			limits.ConnectionDownload = rate
+		default:
+			return stacktrace.NewError("line %d: unknown limit %s", lineNumber, fields[0])
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ParseByteSize parses a number of bytes, e.g a rate in bytes per second,
+// with an optional K, M or G (binary) suffix, e.g 512K.
+// Empty is 0.
+func ParseByteSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate < 0 || rate > math.MaxInt64/multiplier {
+		return 0, stacktrace.NewError("invalid byte size %s", value)
+	}
+
+	return rate * multiplier, nil
+}
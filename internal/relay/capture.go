@@ -0,0 +1,195 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/pcapng"
+)
+
+var (
+	// unixServerCaptureIP and unixClientCaptureIP stand in for unix socket endpoints in captures,
+	// which have no IP and port.
+	unixServerCaptureIP = net.IPv4(127, 0, 0, 1)
+	unixClientCaptureIP = net.IPv4(127, 0, 0, 2)
+)
+
+// Capture configures writing relayed connections to pcapng files.
+type Capture struct {
+	// Prefix of the pcapng files, e.g `/var/tmp/gocat` for `/var/tmp/gocat-<timestamp>.pcapng` files.
+	Prefix string
+	// PerConnection writes each connection to its own `<prefix>-conn<ID>-<timestamp>.pcapng` files,
+	// instead of all connections to the same files.
+	PerConnection bool
+	// MaxFileSize and MaxFileAge rotate to a new file once exceeded. Never rotated if 0.
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+}
+
+// Capturer writes both legs of relayed connections, client to gocat and gocat to the source,
+// as TCP/IP packets to pcapng files.
+// Unix socket endpoints are given 127.0.0.1 (listening side) and 127.0.0.2 (connecting side) addresses.
+type Capturer struct {
+	config Capture
+	logger logger.Logger
+	shared *pcapng.RotatingFile
+}
+
+func NewCapturer(config Capture, logger logger.Logger) *Capturer {
+	result := &Capturer{
+		config: config,
+		logger: logger,
+	}
+
+	if !config.PerConnection {
+		result.shared = pcapng.NewRotatingFile(config.Prefix, config.MaxFileSize, config.MaxFileAge)
+	}
+
+	return result
+}
+
+// Close closes the file shared by all connections.
+func (c *Capturer) Close() error {
+	if c.shared == nil {
+		return nil
+	}
+
+	return c.shared.Close()
+}
+
+// connection starts capturing the connection of `clientConn` relayed to `sourceConn`.
+func (c *Capturer) connection(id uint64, clientConn, sourceConn net.Conn) *connectionCapture {
+	file := c.shared
+	if file == nil {
+		prefix := fmt.Sprintf("%s-conn%d", c.config.Prefix, id)
+		file = pcapng.NewRotatingFile(prefix, c.config.MaxFileSize, c.config.MaxFileAge)
+	}
+
+	result := &connectionCapture{
+		capturer: c,
+		file:     file,
+		clientLeg: pcapng.NewTCPFlow(
+			captureAddr(clientConn.RemoteAddr(), unixClientCaptureIP, id),
+			captureAddr(clientConn.LocalAddr(), unixServerCaptureIP, id),
+		),
+		sourceLeg: pcapng.NewTCPFlow(
+			captureAddr(sourceConn.LocalAddr(), unixClientCaptureIP, id),
+			captureAddr(sourceConn.RemoteAddr(), unixServerCaptureIP, id),
+		),
+	}
+
+	result.mu.Lock()
+	defer result.mu.Unlock()
+
+	result.writeLocked(result.clientLeg.Open())
+	result.writeLocked(result.sourceLeg.Open())
+
+	return result
+}
+
+// captureAddr derives a TCP address of `addr` for captures.
+// Unix socket addresses are given `unixIP` and a port derived from their name,
+// or from the connection `id` for unnamed sockets.
+func captureAddr(addr net.Addr, unixIP net.IP, id uint64) *net.TCPAddr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a
+	case interface{ Parent() net.Addr }:
+		return captureAddr(a.Parent(), unixIP, id)
+	}
+
+	name := ""
+	if addr != nil {
+		name = addr.String()
+	}
+
+	// NOTE: Ports from 1024 onwards, clear of well-known ones.
+	port := 1024 + int(id%64511)
+	if name != "" && name != "@" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(name))
+		port = 1024 + int(hash.Sum32()%64511)
+	}
+
+	return &net.TCPAddr{IP: unixIP, Port: port}
+}
+
+type connectionCapture struct {
+	capturer  *Capturer
+	file      *pcapng.RotatingFile
+	clientLeg *pcapng.TCPFlow
+	sourceLeg *pcapng.TCPFlow
+
+	mu     sync.Mutex
+	failed bool
+	closed bool
+}
+
+// fromClient captures data sent by the client, relayed to the source.
+func (c *connectionCapture) fromClient(payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeLocked(c.clientLeg.Data(true, payload))
+	c.writeLocked(c.sourceLeg.Data(true, payload))
+}
+
+// fromSource captures data sent by the source, relayed to the client.
+func (c *connectionCapture) fromSource(payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeLocked(c.sourceLeg.Data(false, payload))
+	c.writeLocked(c.clientLeg.Data(false, payload))
+}
+
+func (c *connectionCapture) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeLocked(c.clientLeg.Close())
+	c.writeLocked(c.sourceLeg.Close())
+	c.closed = true
+
+	if c.file != c.capturer.shared {
+		_ = c.file.Close()
+	}
+}
+
+func (c *connectionCapture) writeLocked(packets [][]byte) {
+	// NOTE: Data still being relayed after closing would create a new per-connection file.
+	if c.failed || c.closed {
+		return
+	}
+
+	now := time.Now()
+	for _, packet := range packets {
+		err := c.file.WritePacket(now, packet)
+		if err != nil {
+			// NOTE: Log once per connection instead of for every packet.
+			c.failed = true
+			logging.WithError(c.capturer.logger, err).Errorf("Could not capture packets")
+			return
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/metrics"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+var errCircuitOpen = stacktrace.NewError("circuit breaker is open")
+
+// CircuitBreakerConfig configures the circuit breaker of source dials.
+// The breaker is disabled when both `ConsecutiveFailures` and `FailureRatio` are 0.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the breaker after this many failed dials in a row.
+	ConsecutiveFailures int
+	// FailureRatio opens the breaker when the ratio of failed dials
+	// out of the last `RatioWindow` dials reaches it.
+	FailureRatio float64
+	RatioWindow  int
+	// Cooldown is how long the breaker stays open before letting a single probe dial through.
+	Cooldown time.Duration
+}
+
+func (c CircuitBreakerConfig) enabled() bool {
+	return c.ConsecutiveFailures > 0 || c.FailureRatio > 0
+}
+
+type circuitBreaker struct {
+	config    CircuitBreakerConfig
+	logger    logger.Logger
+	metrics   metrics.Recorder
+	relayName string
+
+	mu                  sync.Mutex
+	state               circuitState
+	openedAt            time.Time
+	probing             bool
+	consecutiveFailures int
+	outcomes            []bool
+	outcomesIdx         int
+	outcomesCount       int
+}
+
+func newCircuitBreaker(
+	config CircuitBreakerConfig,
+	logger logger.Logger,
+	recorder metrics.Recorder,
+	relayName string,
+) *circuitBreaker {
+	if !config.enabled() {
+		return nil
+	}
+
+	if config.RatioWindow < 1 {
+		config.RatioWindow = 1
+	}
+
+	cb := &circuitBreaker{
+		config:    config,
+		logger:    logger,
+		metrics:   recorder,
+		relayName: relayName,
+		outcomes:  make([]bool, config.RatioWindow),
+	}
+	cb.reportState()
+
+	return cb
+}
+
+// allow returns `errCircuitOpen` when a dial must not be attempted.
+// When it returns nil, the caller must report the dial outcome via `record`, or `cancelProbe` if unknown.
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.config.Cooldown {
+			cb.metrics.IncrCounter("circuit_breaker_rejections_total", 1, metrics.NewTag("relay", cb.relayName))
+			return errCircuitOpen
+		}
+
+		cb.transition(circuitHalfOpen)
+		cb.probing = true
+		return nil
+	case circuitHalfOpen:
+		if cb.probing {
+			cb.metrics.IncrCounter("circuit_breaker_rejections_total", 1, metrics.NewTag("relay", cb.relayName))
+			return errCircuitOpen
+		}
+
+		cb.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (cb *circuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	failed := err != nil
+	cb.outcomes[cb.outcomesIdx] = failed
+	cb.outcomesIdx = (cb.outcomesIdx + 1) % len(cb.outcomes)
+	if cb.outcomesCount < len(cb.outcomes) {
+		cb.outcomesCount++
+	}
+
+	if !failed {
+		cb.consecutiveFailures = 0
+		if cb.state == circuitHalfOpen {
+			cb.probing = false
+			cb.resetOutcomes()
+			cb.transition(circuitClosed)
+		}
+
+		return
+	}
+
+	cb.consecutiveFailures++
+	switch {
+	case cb.state == circuitHalfOpen:
+		cb.probing = false
+		cb.open()
+	case cb.config.ConsecutiveFailures > 0 && cb.consecutiveFailures >= cb.config.ConsecutiveFailures:
+		cb.open()
+	case cb.config.FailureRatio > 0 && cb.outcomesCount == len(cb.outcomes) && cb.failureRatio() >= cb.config.FailureRatio:
+		cb.open()
+	}
+}
+
+// cancelProbe releases the probe slot taken by `allow` for a dial whose outcome is unknown,
+// e.g cancelled, without counting it as a success or a failure.
+func (cb *circuitBreaker) cancelProbe() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitHalfOpen {
+		cb.probing = false
+	}
+}
+
+func (cb *circuitBreaker) failureRatio() float64 {
+	failures := 0
+	for _, failed := range cb.outcomes[:cb.outcomesCount] {
+		if failed {
+			failures++
+		}
+	}
+
+	return float64(failures) / float64(cb.outcomesCount)
+}
+
+func (cb *circuitBreaker) open() {
+	cb.openedAt = time.Now()
+	cb.consecutiveFailures = 0
+	cb.resetOutcomes()
+	cb.transition(circuitOpen)
+}
+
+func (cb *circuitBreaker) resetOutcomes() {
+	cb.outcomesIdx = 0
+	cb.outcomesCount = 0
+}
+
+func (cb *circuitBreaker) transition(state circuitState) {
+	if cb.state == state {
+		return
+	}
+
+	previous := cb.state
+	cb.state = state
+	if state == circuitOpen {
+		cb.logger.Warnf(
+			"Circuit breaker of %s changed from %s to %s. Rejecting connections for %s",
+			cb.relayName,
+			previous,
+			state,
+			cb.config.Cooldown,
+		)
+	} else {
+		cb.logger.Infof("Circuit breaker of %s changed from %s to %s", cb.relayName, previous, state)
+	}
+
+	cb.reportState()
+}
+
+func (cb *circuitBreaker) reportState() {
+	cb.metrics.SetGauge("circuit_breaker_state", float64(cb.state), metrics.NewTag("relay", cb.relayName))
+}
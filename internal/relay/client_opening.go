@@ -0,0 +1,142 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const defaultHandshakeMaxSize = 4096
+
+var errFirstByteTimeout = stacktrace.NewError("client sent no data in time")
+
+// ClientOpening guards against clients connecting and then sending nothing, or too slowly.
+type ClientOpening struct {
+	// FirstByteTimeout closes client connections that send nothing within it. Disabled if 0.
+	FirstByteTimeout time.Duration
+	// DeferDial dials the source only once the client sent its first bytes,
+	// instead of right after accepting it.
+	// Unsuitable for protocols where the server speaks first.
+	DeferDial bool
+	// HandshakeDelimiter, if not empty, defers dialing the source until the client sent data containing it,
+	// e.g "\r\n" ending the SSH identification string, within `FirstByteTimeout`.
+	HandshakeDelimiter []byte
+	// HandshakeMaxSize closes client connections sending more than this before the delimiter.
+	HandshakeMaxSize int
+}
+
+func (c ClientOpening) defersDial() bool {
+	return c.DeferDial || len(c.HandshakeDelimiter) > 0
+}
+
+// awaitClientOpening reads the first bytes of the client, or its handshake,
+// returning a connection that reads them again and the rejection reason on failure.
+func (r *AbstractDuplexRelay) awaitClientOpening(conn net.Conn) (net.Conn, string, error) {
+	timeout := r.clientOpening.FirstByteTimeout
+	if timeout <= 0 {
+		timeout = readDeadlineTimeout
+	}
+
+	maxSize := r.clientOpening.HandshakeMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultHandshakeMaxSize
+	}
+
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, "", err
+	}
+
+	delimiter := r.clientOpening.HandshakeDelimiter
+	var opening []byte
+	buffer := make([]byte, r.bufferSize)
+	for {
+		n, err := conn.Read(buffer)
+		opening = append(opening, buffer[:n]...)
+		if err != nil {
+			if os.IsTimeout(err) {
+				if len(delimiter) > 0 {
+					return nil, "handshake_timeout", err
+				}
+
+				return nil, "first_byte_timeout", err
+			}
+
+			return nil, "", err
+		}
+
+		if len(delimiter) < 1 && len(opening) > 0 {
+			break
+		}
+
+		if len(delimiter) > 0 && bytes.Contains(opening, delimiter) {
+			break
+		}
+
+		if len(opening) > maxSize {
+			return nil, "handshake_too_large", stacktrace.NewError("handshake exceeds %d bytes", maxSize)
+		}
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &peekedConn{Conn: conn, peeked: opening}, "", nil
+}
+
+// firstByteConn fails its first read with `errFirstByteTimeout`
+// if the client sends nothing within `timeout` since accepted.
+type firstByteConn struct {
+	net.Conn
+	deadline time.Time
+	read     bool
+}
+
+func newFirstByteConn(conn net.Conn, timeout time.Duration) *firstByteConn {
+	return &firstByteConn{
+		Conn:     conn,
+		deadline: time.Now().Add(timeout),
+	}
+}
+
+func (c *firstByteConn) Read(b []byte) (int, error) {
+	if c.read {
+		return c.Conn.Read(b)
+	}
+
+	// NOTE: Overrides the (longer) read deadline set by an outer `DeadlineConnection`.
+	err := c.Conn.SetReadDeadline(c.deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.read = true
+	}
+
+	if err != nil && os.IsTimeout(err) {
+		return n, errFirstByteTimeout
+	}
+
+	return n, err
+}
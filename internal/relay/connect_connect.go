@@ -0,0 +1,136 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+)
+
+// ConnectConnect relays between two endpoints it both dials,
+// the `source` service (unix socket or TCP) and a rendezvous TCP endpoint
+// that pairs the pre-opened tunnels with its own clients.
+type ConnectConnect struct {
+	AbstractDuplexRelay
+}
+
+func NewConnectConnect(
+	logger logger.Logger,
+	healthCheckInterval time.Duration,
+	sourceNetwork,
+	sourceAddress,
+	rendezvousAddress string,
+	idleTunnels,
+	bufferSize int,
+	opts ...Option,
+) (*ConnectConnect, error) {
+	relayOptions := newOptions(opts)
+
+	var sourceName string
+	switch sourceNetwork {
+	case "unix":
+		sourceName = "unix socket"
+
+		err := validateUnixSocketPath(sourceAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		sourceNetwork = relayOptions.unixNetwork()
+	case "tcp":
+		if relayOptions.seqPacket {
+			return nil, stacktrace.NewError("seqpacket requires a unix source network")
+		}
+		sourceName = "TCP connection"
+	default:
+		return nil, stacktrace.NewError("unsupported source network %s. Expected unix or tcp", sourceNetwork)
+	}
+
+	err := relayOptions.validateFraming()
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = net.SplitHostPort(rendezvousAddress)
+	if err != nil {
+		return nil, stacktrace.Propagate(
+			err,
+			"wrong format for rendezvous address %s. Expected <addr>:<port>",
+			rendezvousAddress,
+		)
+	}
+
+	if idleTunnels < 1 {
+		return nil, stacktrace.NewError("at least 1 idle tunnel is required, got %d", idleTunnels)
+	}
+
+	result := &ConnectConnect{
+		AbstractDuplexRelay{
+			healthCheckInterval: healthCheckInterval,
+			logger:              logger,
+			bufferSize:          bufferSize,
+			sourceName:          sourceName,
+			destinationName:     "rendezvous TCP connection",
+			destinationAddr:     rendezvousAddress,
+			dialSourceConn: func(ctx context.Context) (net.Conn, error) {
+				dialer := &net.Dialer{}
+				conn, err := dialer.DialContext(ctx, sourceNetwork, sourceAddress)
+				if err != nil {
+					return nil, stacktrace.Propagate(
+						err,
+						"failed to dial %s address: %s",
+						sourceNetwork,
+						sourceAddress,
+					)
+				}
+
+				return conn, nil
+			},
+			listenTargetConn: func(ctx context.Context) (net.Listener, error) {
+				return newRendezvousListener(
+					ctx,
+					logger,
+					rendezvousAddress,
+					idleTunnels,
+					relayOptions.tunnelHandshake,
+					func(ctx context.Context) (net.Conn, error) {
+						dialer := &net.Dialer{
+							KeepAlive: tcpKeepAlivePeriod,
+						}
+						conn, err := dialer.DialContext(ctx, "tcp", rendezvousAddress)
+						if err != nil {
+							return nil, stacktrace.Propagate(
+								err,
+								"failed to dial rendezvous TCP address: %s",
+								rendezvousAddress,
+							)
+						}
+
+						return conn, nil
+					},
+				), nil
+			},
+		},
+	}
+	result.configure(relayOptions, "connect-connect")
+	result.frameDestination = relayOptions.lengthPrefixFraming
+	result.seqPacketSource = relayOptions.seqPacket
+
+	return result, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sumup-oss/gocat/internal/metrics"
+)
+
+// ConnectionLimits caps concurrently relayed connections.
+// Limits of 0 are unlimited.
+type ConnectionLimits struct {
+	// MaxConnections caps connections across all clients.
+	MaxConnections int
+	// MaxPerClient caps connections per client IP, or per UID for unix socket clients.
+	MaxPerClient int
+	// QueueTimeout is how long excess connections wait for a free slot.
+	// Excess connections are rejected right away when 0.
+	QueueTimeout time.Duration
+}
+
+func (l ConnectionLimits) enabled() bool {
+	return l.MaxConnections > 0 || l.MaxPerClient > 0
+}
+
+type connectionLimiter struct {
+	limits    ConnectionLimits
+	metrics   metrics.Recorder
+	relayName string
+
+	mu        sync.Mutex
+	total     int
+	perClient map[string]int
+	queued    int
+	// released is closed, and replaced, whenever a slot is freed to wake up queued connections.
+	released chan struct{}
+}
+
+func newConnectionLimiter(limits ConnectionLimits, recorder metrics.Recorder, relayName string) *connectionLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+
+	return &connectionLimiter{
+		limits:    limits,
+		metrics:   recorder,
+		relayName: relayName,
+		perClient: make(map[string]int),
+		released:  make(chan struct{}),
+	}
+}
+
+// acquire takes a connection slot for `clientKey`, queueing up to the queue timeout.
+// It returns the rejection reason when no slot is available.
+func (l *connectionLimiter) acquire(ctx context.Context, clientKey string) (string, bool) {
+	var timeout <-chan time.Time
+	queued := false
+	defer func() {
+		if queued {
+			l.mu.Lock()
+			l.queued--
+			l.reportLocked()
+			l.mu.Unlock()
+		}
+	}()
+
+	for {
+		l.mu.Lock()
+		reason := l.exceededLocked(clientKey)
+		if reason == "" {
+			l.total++
+			l.perClient[clientKey]++
+			l.reportLocked()
+			l.mu.Unlock()
+			return "", true
+		}
+
+		if l.limits.QueueTimeout <= 0 {
+			l.mu.Unlock()
+			return reason, false
+		}
+
+		if !queued {
+			queued = true
+			l.queued++
+			l.reportLocked()
+			timer := time.NewTimer(l.limits.QueueTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timeout:
+			return reason, false
+		case <-ctx.Done():
+			return reason, false
+		}
+	}
+}
+
+func (l *connectionLimiter) exceededLocked(clientKey string) string {
+	if l.limits.MaxConnections > 0 && l.total >= l.limits.MaxConnections {
+		return "connection_limit"
+	}
+
+	if l.limits.MaxPerClient > 0 && l.perClient[clientKey] >= l.limits.MaxPerClient {
+		return "client_connection_limit"
+	}
+
+	return ""
+}
+
+func (l *connectionLimiter) release(clientKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	l.perClient[clientKey]--
+	if l.perClient[clientKey] <= 0 {
+		delete(l.perClient, clientKey)
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+	l.reportLocked()
+}
+
+func (l *connectionLimiter) reportLocked() {
+	tag := metrics.NewTag("relay", l.relayName)
+	l.metrics.SetGauge("connections_active", float64(l.total), tag)
+	l.metrics.SetGauge("connections_queued", float64(l.queued), tag)
+}
+
+// connectionLimitKey identifies the client of `conn` for per-client limits,
+// by UID for unix sockets and by IP otherwise.
+func connectionLimitKey(conn net.Conn, credentials *PeerCredentials) string {
+	if credentials != nil {
+		return fmt.Sprintf("uid=%d", credentials.UID)
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	if ip != nil {
+		return ip.String()
+	}
+
+	return conn.RemoteAddr().String()
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/metrics"
+)
+
+const rateLimiterPruneInterval = time.Minute
+
+// RateLimits throttle accepted connections with token buckets.
+// Rates of 0 are unlimited.
+type RateLimits struct {
+	// Rate is the number of connections per second accepted across all clients, with bursts of up to `Burst`.
+	Rate  float64
+	Burst int
+	// ClientRate is the number of connections per second accepted per client IP, or per UID for unix socket clients,
+	// with bursts of up to `ClientBurst`.
+	ClientRate  float64
+	ClientBurst int
+	// BanAfter bans a client for `BanDuration` once it exceeded `ClientRate` this many times
+	// without letting its bucket refill in between. Disabled if 0.
+	BanAfter    int
+	BanDuration time.Duration
+}
+
+func (l RateLimits) enabled() bool {
+	return l.Rate > 0 || l.ClientRate > 0
+}
+
+// tokenBucket holds up to `burst` tokens, refilled at `rate` tokens per second.
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		lastFill: now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.lastFill).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.lastFill = now
+}
+
+func (b *tokenBucket) full() bool {
+	return b.tokens >= b.burst
+}
+
+// available refills the bucket and reports whether it has a token to take.
+func (b *tokenBucket) available(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+type clientRate struct {
+	bucket      *tokenBucket
+	violations  int
+	bannedUntil time.Time
+}
+
+type connectionRateLimiter struct {
+	limits    RateLimits
+	logger    logger.Logger
+	metrics   metrics.Recorder
+	relayName string
+
+	mu         sync.Mutex
+	global     *tokenBucket
+	clients    map[string]*clientRate
+	lastPruned time.Time
+}
+
+func newConnectionRateLimiter(
+	limits RateLimits,
+	logger logger.Logger,
+	recorder metrics.Recorder,
+	relayName string,
+) *connectionRateLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+
+	now := time.Now()
+	result := &connectionRateLimiter{
+		limits:     limits,
+		logger:     logger,
+		metrics:    recorder,
+		relayName:  relayName,
+		clients:    make(map[string]*clientRate),
+		lastPruned: now,
+	}
+
+	if limits.Rate > 0 {
+		result.global = newTokenBucket(limits.Rate, limits.Burst, now)
+	}
+
+	return result
+}
+
+// allow takes a token for a new connection of `clientKey`.
+// It returns the rejection reason when the connection must be rejected.
+// NOTE: Tokens are only taken once both the client and the global bucket have one,
+// so connections rejected by the global limit don't use up their client's rate.
+func (l *connectionRateLimiter) allow(clientKey string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+
+	var client *clientRate
+	if l.limits.ClientRate > 0 {
+		var ok bool
+		client, ok = l.clients[clientKey]
+		if !ok {
+			client = &clientRate{bucket: newTokenBucket(l.limits.ClientRate, l.limits.ClientBurst, now)}
+			l.clients[clientKey] = client
+		}
+
+		if now.Before(client.bannedUntil) {
+			return "client_banned", false
+		}
+
+		if !client.bucket.available(now) {
+			client.violations++
+			if l.limits.BanAfter > 0 && client.violations >= l.limits.BanAfter {
+				client.violations = 0
+				client.bannedUntil = now.Add(l.limits.BanDuration)
+				l.logger.Warnf(
+					"Banning client %s for %s after exceeding its connection rate %d times",
+					clientKey,
+					l.limits.BanDuration,
+					l.limits.BanAfter,
+				)
+				l.metrics.IncrCounter("clients_banned_total", 1, metrics.NewTag("relay", l.relayName))
+			}
+
+			return "client_rate_limited", false
+		}
+	}
+
+	if l.global != nil {
+		if !l.global.available(now) {
+			return "rate_limited", false
+		}
+
+		l.global.tokens--
+	}
+
+	if client != nil {
+		client.bucket.tokens--
+
+		// NOTE: Violations are forgiven once the client slowed down enough for its bucket to refill.
+		if client.bucket.tokens+1 >= client.bucket.burst {
+			client.violations = 0
+		}
+	}
+
+	return "", true
+}
+
+// pruneLocked forgets clients that are neither banned nor rate limited anymore.
+func (l *connectionRateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPruned) < rateLimiterPruneInterval {
+		return
+	}
+
+	l.lastPruned = now
+	for key, client := range l.clients {
+		client.bucket.refill(now)
+		if client.bucket.full() && !now.Before(client.bannedUntil) {
+			delete(l.clients, key)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/metrics"
+	"github.com/sumup-oss/gocat/internal/tracing"
+)
+
+// connectionSession tracks a relayed connection from accept to close,
+// for its open and close lifecycle events.
+type connectionSession struct {
+	// NOTE: Keep the atomically updated counters first for 64-bit alignment on 32-bit platforms.
+	bytesIn  uint64
+	bytesOut uint64
+
+	id                 uint64
+	client             string
+	destinationAddress string
+	accepted           time.Time
+	conn               net.Conn
+	// logger adds the connection ID and client to every entry.
+	logger logger.Logger
+	// ctx is done once relaying stops or the connection is killed, interrupting waits like bandwidth shaping.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu                sync.Mutex
+	sourceAddress     string
+	closeReason       string
+	sourceDialStarted time.Time
+	sourceDialed      time.Time
+	firstByte         time.Time
+}
+
+func newConnectionSession(
+	ctx context.Context,
+	id uint64,
+	client string,
+	conn net.Conn,
+	relayLogger logger.Logger,
+) *connectionSession {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &connectionSession{
+		id:                 id,
+		client:             client,
+		destinationAddress: conn.LocalAddr().String(),
+		accepted:           time.Now(),
+		conn:               conn,
+		logger:             logging.With(relayLogger, logging.FieldConnection, id, logging.FieldClient, client),
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+func (s *connectionSession) addBytesIn(n int) {
+	atomic.AddUint64(&s.bytesIn, uint64(n))
+}
+
+func (s *connectionSession) addBytesOut(n int) {
+	atomic.AddUint64(&s.bytesOut, uint64(n))
+}
+
+// setSourceDialed records dialing the source from `started` until now, successfully if `address` is set.
+func (s *connectionSession) setSourceDialed(started time.Time, address string) {
+	s.mu.Lock()
+	s.sourceDialStarted = started
+	s.sourceDialed = time.Now()
+	s.sourceAddress = address
+	s.mu.Unlock()
+}
+
+// markFirstByte records receiving the first bytes from the client, unless already recorded.
+func (s *connectionSession) markFirstByte() {
+	s.mu.Lock()
+	if s.firstByte.IsZero() {
+		s.firstByte = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// setCloseReason records why the connection closed. The first reason wins,
+// since closing one side of the connection makes the other one fail too.
+func (s *connectionSession) setCloseReason(reason string) {
+	s.mu.Lock()
+	if s.closeReason == "" {
+		s.closeReason = reason
+	}
+	s.mu.Unlock()
+}
+
+// kill closes the client side of the connection, which closes the source side once relaying notices.
+func (s *connectionSession) kill() {
+	s.setCloseReason("killed")
+	s.cancel()
+	_ = s.conn.Close()
+}
+
+// info describes the connection while it's relayed.
+func (s *connectionSession) info() ConnectionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return ConnectionInfo{
+		ID:          s.id,
+		Client:      s.client,
+		Destination: s.destinationAddress,
+		Source:      s.sourceAddress,
+		Accepted:    s.accepted,
+		BytesIn:     atomic.LoadUint64(&s.bytesIn),
+		BytesOut:    atomic.LoadUint64(&s.bytesOut),
+	}
+}
+
+// logOpen logs the open event, once the connection is relayed to the source.
+func (s *connectionSession) logOpen() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logging.With(
+		s.logger,
+		logging.FieldDestination, s.destinationAddress,
+		logging.FieldSource, s.sourceAddress,
+	).Info("Opened connection")
+}
+
+// logClose logs the close event.
+func (s *connectionSession) logClose() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logging.With(
+		s.logger,
+		logging.FieldDestination, s.destinationAddress,
+		logging.FieldSource, s.sourceAddress,
+		"bytes_in", atomic.LoadUint64(&s.bytesIn),
+		"bytes_out", atomic.LoadUint64(&s.bytesOut),
+		"duration", time.Since(s.accepted),
+		"reason", s.closeReasonLocked(),
+	).Info("Closed connection")
+}
+
+func (s *connectionSession) closeReasonLocked() string {
+	if s.closeReason == "" {
+		return "unknown"
+	}
+
+	return s.closeReason
+}
+
+// span describes the connection as a tracing span, once it's closed.
+func (s *connectionSession) span(relayName string) *tracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reason := s.closeReasonLocked()
+	span := &tracing.Span{
+		TraceID: tracing.NewTraceID(),
+		SpanID:  tracing.NewSpanID(),
+		Name:    "gocat.connection",
+		Start:   s.accepted,
+		End:     time.Now(),
+		Attributes: []tracing.Attribute{
+			{Key: "gocat.relay", Value: relayName},
+			{Key: "gocat.connection.id", Value: s.id},
+			{Key: "gocat.client", Value: s.client},
+			{Key: "gocat.destination.address", Value: s.destinationAddress},
+			{Key: "gocat.source.address", Value: s.sourceAddress},
+			{Key: "gocat.bytes_in", Value: atomic.LoadUint64(&s.bytesIn)},
+			{Key: "gocat.bytes_out", Value: atomic.LoadUint64(&s.bytesOut)},
+			{Key: "gocat.close_reason", Value: reason},
+		},
+		Events: []tracing.Event{{Name: "accepted", Time: s.accepted}},
+	}
+
+	if !s.sourceDialStarted.IsZero() {
+		span.Events = append(
+			span.Events,
+			tracing.Event{Name: "source_dial_started", Time: s.sourceDialStarted},
+			tracing.Event{Name: "source_dial_finished", Time: s.sourceDialed},
+		)
+	}
+
+	if !s.firstByte.IsZero() {
+		span.Events = append(span.Events, tracing.Event{Name: "first_byte", Time: s.firstByte})
+	}
+
+	sort.SliceStable(span.Events, func(i, j int) bool {
+		return span.Events[i].Time.Before(span.Events[j].Time)
+	})
+
+	span.Events = append(span.Events, tracing.Event{Name: "closed", Time: span.End})
+
+	if reason != "client_closed" && reason != "source_closed" {
+		span.Error = reason
+	}
+
+	return span
+}
+
+// recordMetrics reports the bytes, durations and close reason of the connection, once it's closed.
+func (s *connectionSession) recordMetrics(recorder metrics.Recorder, relayName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag := metrics.NewTag("relay", relayName)
+	recorder.IncrCounter(
+		"connections_closed_total",
+		1,
+		tag,
+		metrics.NewTag("reason", s.closeReasonLocked()),
+	)
+	recorder.IncrCounter(
+		"bytes_total",
+		int64(atomic.LoadUint64(&s.bytesIn)),
+		tag,
+		metrics.NewTag("direction", "in"),
+	)
+	recorder.IncrCounter(
+		"bytes_total",
+		int64(atomic.LoadUint64(&s.bytesOut)),
+		tag,
+		metrics.NewTag("direction", "out"),
+	)
+	recorder.ObserveDuration("connection_duration", time.Since(s.accepted), tag)
+
+	if s.sourceAddress != "" {
+		recorder.ObserveDuration("source_dial_duration", s.sourceDialed.Sub(s.sourceDialStarted), tag)
+	}
+}
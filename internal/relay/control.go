@@ -0,0 +1,244 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// RelayInfo describes a running relay.
+type RelayInfo struct {
+	Name               string
+	Source             string
+	Destination        string
+	DestinationAddress string
+	Paused             bool
+	ActiveConnections  int
+	// AcceptedConnections counts the connections accepted since the relay started.
+	AcceptedConnections uint64
+}
+
+// ConnectionInfo describes a connection while it's relayed.
+type ConnectionInfo struct {
+	ID          uint64
+	Client      string
+	Destination string
+	// Source is the address of the source connection, empty until it's dialed.
+	Source   string
+	Accepted time.Time
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Info describes the relay.
+func (r *AbstractDuplexRelay) Info() RelayInfo {
+	return RelayInfo{
+		Name:                r.name,
+		Source:              r.sourceName,
+		Destination:         r.destinationName,
+		DestinationAddress:  r.destinationAddr,
+		Paused:              r.pause.paused(),
+		ActiveConnections:   r.sessions.len(),
+		AcceptedConnections: atomic.LoadUint64(&r.connectionCount),
+	}
+}
+
+// Connections describes the connections being relayed, ordered by ID.
+func (r *AbstractDuplexRelay) Connections() []ConnectionInfo {
+	sessions := r.sessions.list()
+
+	result := make([]ConnectionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, session.info())
+	}
+
+	return result
+}
+
+// KillConnection closes the connection with `id`, reporting whether it was being relayed.
+func (r *AbstractDuplexRelay) KillConnection(id uint64) bool {
+	session := r.sessions.get(id)
+	if session == nil {
+		return false
+	}
+
+	session.logger.Infof("Killing connection")
+	session.kill()
+
+	return true
+}
+
+// Pause stops handing accepted connections over for relaying, until resumed.
+// New clients wait in the listen backlog meanwhile.
+func (r *AbstractDuplexRelay) Pause() {
+	if r.pause.pause() {
+		r.logger.Infof("Paused accepting %s", r.destinationName)
+	}
+}
+
+// Resume undoes Pause.
+func (r *AbstractDuplexRelay) Resume() {
+	if r.pause.resume() {
+		r.logger.Infof("Resumed accepting %s", r.destinationName)
+	}
+}
+
+// CheckHealth runs the health check of the source now, instead of waiting for its interval.
+// Unlike a periodic one, a failed health check doesn't stop the relay.
+// It fails right away if the relay isn't running its health checks.
+func (r *AbstractDuplexRelay) CheckHealth(ctx context.Context) error {
+	select {
+	case <-r.healthCheckStarted:
+	default:
+		return stacktrace.NewError("health checks of %s haven't started yet", r.sourceName)
+	}
+
+	resultCh := make(chan error, 1)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.healthCheckStopped:
+		return stacktrace.NewError("health checks of %s stopped", r.sourceName)
+	case r.healthCheckRequests <- resultCh:
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-resultCh:
+		return err
+	}
+}
+
+// sessionRegistry keeps the sessions of the connections being relayed.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[uint64]*connectionSession
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[uint64]*connectionSession),
+	}
+}
+
+func (r *sessionRegistry) add(session *connectionSession) {
+	r.mu.Lock()
+	r.sessions[session.id] = session
+	r.mu.Unlock()
+}
+
+func (r *sessionRegistry) remove(session *connectionSession) {
+	r.mu.Lock()
+	delete(r.sessions, session.id)
+	r.mu.Unlock()
+}
+
+func (r *sessionRegistry) get(id uint64) *connectionSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.sessions[id]
+}
+
+func (r *sessionRegistry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.sessions)
+}
+
+func (r *sessionRegistry) list() []*connectionSession {
+	r.mu.Lock()
+	result := make([]*connectionSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		result = append(result, session)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+
+	return result
+}
+
+// acceptPause holds accepted connections back while paused.
+type acceptPause struct {
+	mu sync.Mutex
+	// resumed is closed on resume, nil while not paused.
+	resumed chan struct{}
+}
+
+// pause reports whether the relay wasn't paused already.
+func (p *acceptPause) pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed != nil {
+		return false
+	}
+
+	p.resumed = make(chan struct{})
+
+	return true
+}
+
+// resume reports whether the relay was paused.
+func (p *acceptPause) resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed == nil {
+		return false
+	}
+
+	close(p.resumed)
+	p.resumed = nil
+
+	return true
+}
+
+func (p *acceptPause) paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.resumed != nil
+}
+
+// wait returns once not paused, or false if `ctx` is done first.
+func (p *acceptPause) wait(ctx context.Context) bool {
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
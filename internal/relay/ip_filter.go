@@ -0,0 +1,207 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/palantir/stacktrace"
+)
+
+// IPFilter decides whether connections from a client IP are accepted.
+// Deny rules take precedence over allow rules.
+// When there are allow rules, only matching clients are accepted.
+type IPFilter struct {
+	path        string
+	staticAllow []*net.IPNet
+	staticDeny  []*net.IPNet
+
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter creates a filter out of `allow` and `deny` CIDRs (or single IPs)
+// and the rules in the file at `path`, if not empty.
+//
+// Every non-empty line of the file that isn't a `#` comment is either `allow <CIDR>` or `deny <CIDR>`.
+func NewIPFilter(allow, deny []string, path string) (*IPFilter, error) {
+	staticAllow, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid allow rules")
+	}
+
+	staticDeny, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid deny rules")
+	}
+
+	f := &IPFilter{
+		path:        path,
+		staticAllow: staticAllow,
+		staticDeny:  staticDeny,
+	}
+
+	err = f.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reload re-reads the rules file. The previous rules are kept if it's invalid.
+func (f *IPFilter) Reload() error {
+	allow := append([]*net.IPNet(nil), f.staticAllow...)
+	deny := append([]*net.IPNet(nil), f.staticDeny...)
+
+	if f.path != "" {
+		fileAllow, fileDeny, err := readIPRulesFile(f.path)
+		if err != nil {
+			return stacktrace.Propagate(err, "could not load IP rules file %s", f.path)
+		}
+
+		allow = append(allow, fileAllow...)
+		deny = append(deny, fileDeny...)
+	}
+
+	f.mu.Lock()
+	f.allow = allow
+	f.deny = deny
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Allowed reports whether a client with `ip` may connect.
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, ipNet := range f.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(f.allow) < 1 {
+		return true
+	}
+
+	for _, ipNet := range f.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readIPRulesFile(path string) ([]*net.IPNet, []*net.IPNet, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+
+	var allow, deny []*net.IPNet
+
+	scanner := bufio.NewScanner(fd)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, stacktrace.NewError("line %d: expected `allow <CIDR>` or `deny <CIDR>`", lineNumber)
+		}
+
+		ipNet, err := parseCIDR(fields[1])
+		if err != nil {
+			return nil, nil, stacktrace.Propagate(err, "line %d", lineNumber)
+		}
+
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, ipNet)
+		case "deny":
+			deny = append(deny, ipNet)
+		default:
+			return nil, nil, stacktrace.NewError("line %d: unknown action %s", lineNumber, fields[0])
+		}
+	}
+
+	return allow, deny, scanner.Err()
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		ipNet, err := parseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ipNet)
+	}
+
+	return result, nil
+}
+
+// parseCIDR parses a CIDR, treating a single IP as a /32 or /128 network.
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, stacktrace.NewError("invalid IP %s", value)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid CIDR %s", value)
+	}
+
+	return ipNet, nil
+}
+
+// remoteIP returns the IP of a connection's remote address, or nil for non-IP (e.g unix socket) addresses.
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case interface{ Parent() net.Addr }:
+		return remoteIP(a.Parent())
+	default:
+		return nil
+	}
+}
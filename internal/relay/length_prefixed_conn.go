@@ -0,0 +1,71 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+
+	"github.com/palantir/stacktrace"
+)
+
+const lengthPrefixSize = 4
+
+// lengthPrefixedConn preserves message boundaries over a stream connection,
+// prefixing each written message with its 4 bytes big-endian length.
+// Each read returns exactly one message.
+type lengthPrefixedConn struct {
+	net.Conn
+}
+
+func newLengthPrefixedConn(conn net.Conn) *lengthPrefixedConn {
+	return &lengthPrefixedConn{Conn: conn}
+}
+
+func (c *lengthPrefixedConn) Read(p []byte) (int, error) {
+	var header [lengthPrefixSize]byte
+	_, err := io.ReadFull(c.Conn, header[:])
+	if err != nil {
+		return 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if uint64(length) > uint64(len(p)) {
+		return 0, stacktrace.NewError("message of %d bytes exceeds buffer of %d bytes", length, len(p))
+	}
+
+	return io.ReadFull(c.Conn, p[:length])
+}
+
+func (c *lengthPrefixedConn) Write(p []byte) (int, error) {
+	if uint64(len(p)) > math.MaxUint32 {
+		return 0, stacktrace.NewError("message of %d bytes is too large to frame", len(p))
+	}
+
+	// NOTE: Write header and message at once, so concurrent writers can't interleave them.
+	frame := make([]byte, lengthPrefixSize+len(p))
+	binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	copy(frame[lengthPrefixSize:], p)
+
+	n, err := c.Conn.Write(frame)
+	n -= lengthPrefixSize
+	if n < 0 {
+		n = 0
+	}
+
+	return n, err
+}
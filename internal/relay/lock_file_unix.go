@@ -0,0 +1,51 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package relay
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/palantir/stacktrace"
+)
+
+// lockFile takes an exclusive, non-blocking lock of the file at `path`.
+// The file is kept when unlocked, since removing it would race with other instances locking it.
+func lockFile(path string) (*os.File, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to open lock file %s", path)
+	}
+
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		_ = fd.Close()
+		return nil, stacktrace.Propagate(err, "failed to lock %s", path)
+	}
+
+	return fd, nil
+}
+
+func unlockFile(fd *os.File) {
+	if fd == nil {
+		return
+	}
+
+	_ = syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+	_ = fd.Close()
+}
@@ -0,0 +1,269 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/mux"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// muxDialer opens every source connection as a stream
+// of a single mux session to the paired gocat instance.
+type muxDialer struct {
+	logger  logger.Logger
+	address string
+	dial    func(context.Context) (net.Conn, error)
+
+	mu         sync.Mutex
+	session    *mux.Session
+	connecting *muxConnectAttempt
+}
+
+// muxConnectAttempt is a mux session dial shared by all connections waiting for a session.
+type muxConnectAttempt struct {
+	done    chan struct{}
+	session *mux.Session
+	err     error
+}
+
+func newMuxDialer(
+	logger logger.Logger,
+	address string,
+	dial func(context.Context) (net.Conn, error),
+) *muxDialer {
+	return &muxDialer{
+		logger:  logger,
+		address: address,
+		dial:    dial,
+	}
+}
+
+func (d *muxDialer) open(ctx context.Context) (net.Conn, error) {
+	session, err := d.establishedSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := session.Open()
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to open mux stream to %s", d.address)
+	}
+
+	return stream, nil
+}
+
+// establishedSession returns the open mux session, or dials a new one.
+// NOTE: The session is dialed without holding the lock,
+// and connections arriving meanwhile wait for the same attempt instead of dialing their own.
+func (d *muxDialer) establishedSession(ctx context.Context) (*mux.Session, error) {
+	d.mu.Lock()
+	if d.session != nil && !d.session.IsClosed() {
+		session := d.session
+		d.mu.Unlock()
+		return session, nil
+	}
+
+	attempt := d.connecting
+	if attempt != nil {
+		d.mu.Unlock()
+
+		select {
+		case <-attempt.done:
+			return attempt.session, attempt.err
+		case <-ctx.Done():
+			return nil, stacktrace.Propagate(ctx.Err(), "gave up waiting for mux session to %s", d.address)
+		}
+	}
+
+	if d.session != nil {
+		logging.WithError(d.logger, d.session.Err()).Warnf("Mux session to %s closed, reconnecting", d.address)
+	}
+
+	attempt = &muxConnectAttempt{done: make(chan struct{})}
+	d.connecting = attempt
+	d.mu.Unlock()
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		attempt.err = stacktrace.Propagate(err, "failed to establish mux session to %s", d.address)
+	} else {
+		attempt.session = mux.Client(conn)
+		d.logger.Infof("Established mux session to %s", d.address)
+	}
+
+	d.mu.Lock()
+	if attempt.session != nil {
+		d.session = attempt.session
+	}
+	d.connecting = nil
+	d.mu.Unlock()
+
+	close(attempt.done)
+	return attempt.session, attempt.err
+}
+
+// muxListener accepts mux sessions from paired gocat instances
+// and in turn accepts the streams opened over all of them.
+type muxListener struct {
+	net.Listener
+	logger  logger.Logger
+	streams chan net.Conn
+	closed  chan struct{}
+
+	mu       sync.Mutex
+	sessions map[*mux.Session]struct{}
+}
+
+func newMuxListener(listener net.Listener, logger logger.Logger) *muxListener {
+	l := &muxListener{
+		Listener: listener,
+		logger:   logger,
+		streams:  make(chan net.Conn),
+		closed:   make(chan struct{}),
+		sessions: make(map[*mux.Session]struct{}),
+	}
+
+	go l.acceptSessions()
+
+	return l
+}
+
+func (l *muxListener) acceptSessions() {
+	defer close(l.closed)
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			netErr, ok := err.(net.Error)
+			if ok && netErr.Temporary() {
+				time.Sleep(redialMinBackoff)
+				continue
+			}
+
+			return
+		}
+
+		go l.serveSession(mux.Server(conn))
+	}
+}
+
+func (l *muxListener) serveSession(session *mux.Session) {
+	l.mu.Lock()
+	l.sessions[session] = struct{}{}
+	l.mu.Unlock()
+
+	l.logger.Infof("Established mux session from %s", session.RemoteAddr())
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.sessions, session)
+		l.mu.Unlock()
+
+		_ = session.Close()
+		l.logger.Infof("Closed mux session from %s. Reason: %s", session.RemoteAddr(), session.Err())
+	}()
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+
+		select {
+		case l.streams <- stream:
+		case <-l.closed:
+			_ = stream.Close()
+			return
+		}
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case <-l.closed:
+		return nil, mux.ErrSessionClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	err := l.Listener.Close()
+
+	l.mu.Lock()
+	for session := range l.sessions {
+		_ = session.Close()
+	}
+	l.mu.Unlock()
+
+	return err
+}
+
+// dialTLS wraps `dial` with a TLS client handshake.
+func dialTLS(
+	dial func(context.Context) (net.Conn, error),
+	config *tls.Config,
+) func(context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(tlsHandshakeTimeout)
+		}
+
+		tlsConn := tls.Client(conn, config)
+		_ = tlsConn.SetDeadline(deadline)
+
+		err = tlsConn.Handshake()
+		if err != nil {
+			_ = conn.Close()
+			return nil, stacktrace.Propagate(err, "failed TLS handshake with %s", conn.RemoteAddr())
+		}
+
+		_ = tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// tlsConfigWithServerName defaults the verified server name to the host of `address`.
+func tlsConfigWithServerName(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" {
+		return config
+	}
+
+	result := config.Clone()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	result.ServerName = host
+	return result
+}
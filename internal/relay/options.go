@@ -0,0 +1,277 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/metrics"
+	"github.com/sumup-oss/gocat/internal/tracing"
+)
+
+const defaultSourceResolveInterval = 30 * time.Second
+
+// Option configures optional behaviour of a relay.
+// Relays created without options behave the same way they always did.
+type Option func(*options)
+
+type options struct {
+	name                  string
+	metrics               metrics.Recorder
+	circuitBreaker        CircuitBreakerConfig
+	sourcePoolSize        int
+	sourcePoolMaxIdle     time.Duration
+	sourceResolveMode     SourceResolveMode
+	sourceResolveInterval time.Duration
+	dnsServer             string
+	ipFilter              *IPFilter
+	connectionLimits      ConnectionLimits
+	rateLimits            RateLimits
+	bandwidthShaper       *BandwidthShaper
+	clientOpening         ClientOpening
+	trafficDumper         *TrafficDumper
+	capturer              *Capturer
+	sessionRecorder       *SessionRecorder
+	logLevel              *logger.Level
+	accessLog             *AccessLog
+	tracer                *tracing.Exporter
+	peerAuthorizer        *PeerAuthorizer
+	unixSocketPermissions *UnixSocketPermissions
+	unixSocketLock        bool
+	seqPacket             bool
+	lengthPrefixFraming   bool
+	multiplex             bool
+	multiplexTLS          *tls.Config
+	tunnelHandshake       []byte
+}
+
+func newOptions(opts []Option) *options {
+	result := &options{
+		metrics:               metrics.Nop{},
+		sourceResolveMode:     SourceResolveStatic,
+		sourceResolveInterval: defaultSourceResolveInterval,
+	}
+
+	for _, opt := range opts {
+		opt(result)
+	}
+
+	return result
+}
+
+// unixNetwork is the network of the relay's unix socket side.
+func (o *options) unixNetwork() string {
+	if o.seqPacket {
+		return "unixpacket"
+	}
+
+	return "unix"
+}
+
+func (o *options) validateFraming() error {
+	if o.lengthPrefixFraming && !o.seqPacket {
+		return stacktrace.NewError("length-prefix framing requires a seqpacket unix socket")
+	}
+
+	return nil
+}
+
+// WithName sets the name identifying the relay in logs and metrics.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithMetrics sets where the relay reports its metrics.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(o *options) {
+		o.metrics = recorder
+	}
+}
+
+// WithCircuitBreaker guards dialing the source with a circuit breaker.
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(o *options) {
+		o.circuitBreaker = config
+	}
+}
+
+// WithSourcePool keeps `size` connections to the source pre-dialed,
+// discarding the ones idle for longer than `maxIdle` (if not 0) instead of handing them out.
+func WithSourcePool(size int, maxIdle time.Duration) Option {
+	return func(o *options) {
+		o.sourcePoolSize = size
+		o.sourcePoolMaxIdle = maxIdle
+	}
+}
+
+// WithIPFilter rejects accepted connections from client IPs not allowed by `filter`.
+func WithIPFilter(filter *IPFilter) Option {
+	return func(o *options) {
+		o.ipFilter = filter
+	}
+}
+
+// WithConnectionLimits caps concurrently relayed connections, globally and per client.
+func WithConnectionLimits(limits ConnectionLimits) Option {
+	return func(o *options) {
+		o.connectionLimits = limits
+	}
+}
+
+// WithRateLimits throttles how fast connections are accepted, globally and per client.
+func WithRateLimits(limits RateLimits) Option {
+	return func(o *options) {
+		o.rateLimits = limits
+	}
+}
+
+// WithBandwidthShaper throttles the data relayed by connections.
+func WithBandwidthShaper(shaper *BandwidthShaper) Option {
+	return func(o *options) {
+		o.bandwidthShaper = shaper
+	}
+}
+
+// WithClientOpening guards against clients connecting and then sending nothing, or too slowly.
+func WithClientOpening(opening ClientOpening) Option {
+	return func(o *options) {
+		o.clientOpening = opening
+	}
+}
+
+// WithTrafficDumper dumps the data relayed by connections.
+func WithTrafficDumper(dumper *TrafficDumper) Option {
+	return func(o *options) {
+		o.trafficDumper = dumper
+	}
+}
+
+// WithCapturer writes relayed connections to pcapng files.
+func WithCapturer(capturer *Capturer) Option {
+	return func(o *options) {
+		o.capturer = capturer
+	}
+}
+
+// WithTracer exports a span per relayed connection to `tracer`.
+func WithTracer(tracer *tracing.Exporter) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithAccessLog writes a line per finished connection to `accessLog`.
+func WithAccessLog(accessLog *AccessLog) Option {
+	return func(o *options) {
+		o.accessLog = accessLog
+	}
+}
+
+// WithLogLevel overrides the log level of the relay.
+func WithLogLevel(level logger.Level) Option {
+	return func(o *options) {
+		o.logLevel = &level
+	}
+}
+
+// WithSessionRecorder records the data relayed by connections for replaying.
+func WithSessionRecorder(recorder *SessionRecorder) Option {
+	return func(o *options) {
+		o.sessionRecorder = recorder
+	}
+}
+
+// WithPeerAuthorizer rejects accepted unix socket connections
+// from local processes not allowed by `authorizer`.
+func WithPeerAuthorizer(authorizer *PeerAuthorizer) Option {
+	return func(o *options) {
+		o.peerAuthorizer = authorizer
+	}
+}
+
+// WithUnixSocketPermissions applies `permissions` to the unix socket a relay listens at.
+func WithUnixSocketPermissions(permissions UnixSocketPermissions) Option {
+	return func(o *options) {
+		o.unixSocketPermissions = &permissions
+	}
+}
+
+// WithUnixSocketLock locks a `<socket path>.lock` file while listening at a unix socket,
+// preventing two instances from racing for the same socket.
+func WithUnixSocketLock() Option {
+	return func(o *options) {
+		o.unixSocketLock = true
+	}
+}
+
+// WithSeqPacket uses `SOCK_SEQPACKET` unix sockets, relaying each packet as a single write.
+// A packet larger than the relay buffer size fails its connection.
+func WithSeqPacket() Option {
+	return func(o *options) {
+		o.seqPacket = true
+	}
+}
+
+// WithLengthPrefixFraming frames packets of a `SOCK_SEQPACKET` unix socket
+// with a 4 bytes big-endian length on the other, stream, side of the relay,
+// so message boundaries are preserved end to end.
+func WithLengthPrefixFraming() Option {
+	return func(o *options) {
+		o.lengthPrefixFraming = true
+	}
+}
+
+// WithSourceResolution sets how a TCP source address is resolved
+// and how often the resolved addresses are refreshed.
+func WithSourceResolution(mode SourceResolveMode, interval time.Duration) Option {
+	return func(o *options) {
+		o.sourceResolveMode = mode
+		if interval > 0 {
+			o.sourceResolveInterval = interval
+		}
+	}
+}
+
+// WithDNSServer makes source resolution query `address` (<host>:<port>)
+// instead of the system configured resolvers.
+func WithDNSServer(address string) Option {
+	return func(o *options) {
+		o.dnsServer = address
+	}
+}
+
+// WithTunnelHandshake makes the rendezvous side claim an idle tunnel by sending `handshake`,
+// which isn't relayed, instead of its first bytes. The source can then speak first, e.g SMTP or MySQL.
+func WithTunnelHandshake(handshake []byte) Option {
+	return func(o *options) {
+		o.tunnelHandshake = handshake
+	}
+}
+
+// WithMultiplexing relays all connections as streams of a single mux session
+// between a `unix-to-tcp` (accepting sessions) and a `tcp-to-unix` (dialing a session) relay.
+// The session is secured with TLS if `tlsConfig` is not nil.
+func WithMultiplexing(tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.multiplex = true
+		o.multiplexTLS = tlsConfig
+	}
+}
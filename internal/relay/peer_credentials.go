@@ -0,0 +1,165 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"net"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/palantir/stacktrace"
+)
+
+// PeerCredentials identify the local process connected to a unix socket.
+// NOTE: Unlike the SO_PEERCRED IDs, `Groups` and `Executable` are read from /proc/<pid> after
+// the process connected. It may have exec'ed another executable or exited, and its PID been reused, since.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+	// Groups are the supplementary groups of the process.
+	Groups     []uint32
+	Executable string
+}
+
+func (c *PeerCredentials) String() string {
+	executable := c.Executable
+	if executable == "" {
+		executable = "unknown"
+	}
+
+	return fmt.Sprintf("pid=%d uid=%d gid=%d exe=%s", c.PID, c.UID, c.GID, executable)
+}
+
+// PeerAuthorizer allows unix socket peers running as one of the users,
+// in one of the groups, effective or supplementary, or as one of the executables.
+// NOTE: Executables are matched by their /proc/<pid>/exe, see `PeerCredentials`,
+// so only allow ones whose users can't make them exec something else.
+type PeerAuthorizer struct {
+	uids        map[uint32]struct{}
+	gids        map[uint32]struct{}
+	executables map[string]struct{}
+}
+
+// NewPeerAuthorizer resolves `users` and `groups`, given as names or numeric IDs, once,
+// and takes `executables` as absolute paths.
+func NewPeerAuthorizer(users, groups, executables []string) (*PeerAuthorizer, error) {
+	if !peerCredentialsSupported {
+		return nil, stacktrace.NewError("unix socket peer credentials are not supported on this platform")
+	}
+
+	a := &PeerAuthorizer{
+		uids:        make(map[uint32]struct{}),
+		gids:        make(map[uint32]struct{}),
+		executables: make(map[string]struct{}),
+	}
+
+	for _, name := range users {
+		uid, err := lookupID(name, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "unknown user %s", name)
+		}
+
+		a.uids[uid] = struct{}{}
+	}
+
+	for _, name := range groups {
+		gid, err := lookupID(name, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "unknown group %s", name)
+		}
+
+		a.gids[gid] = struct{}{}
+	}
+
+	for _, executable := range executables {
+		if !filepath.IsAbs(executable) {
+			return nil, stacktrace.NewError("executable path %s must be absolute", executable)
+		}
+
+		a.executables[filepath.Clean(executable)] = struct{}{}
+	}
+
+	return a, nil
+}
+
+// Allowed reports whether the peer matches any of the rules.
+func (a *PeerAuthorizer) Allowed(credentials *PeerCredentials) bool {
+	if _, ok := a.uids[credentials.UID]; ok {
+		return true
+	}
+
+	if _, ok := a.executables[credentials.Executable]; ok && credentials.Executable != "" {
+		return true
+	}
+
+	if _, ok := a.gids[credentials.GID]; ok {
+		return true
+	}
+
+	for _, gid := range credentials.Groups {
+		if _, ok := a.gids[gid]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lookupID(name string, lookup func(string) (string, error)) (uint32, error) {
+	id, err := strconv.ParseUint(name, 10, 32)
+	if err == nil {
+		return uint32(id), nil
+	}
+
+	value, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(id), nil
+}
+
+// unixPeerCredentials returns the credentials of the peer of an accepted unix socket connection,
+// or nil if `conn` isn't one.
+func unixPeerCredentials(conn net.Conn) (*PeerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil
+	}
+
+	return readPeerCredentials(unixConn)
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/palantir/stacktrace"
+)
+
+const peerCredentialsSupported = true
+
+func readPeerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to get raw unix socket connection")
+	}
+
+	var ucred *syscall.Ucred
+	var sockoptErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, sockoptErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to access unix socket file descriptor")
+	}
+
+	if sockoptErr != nil {
+		return nil, stacktrace.Propagate(sockoptErr, "failed to read SO_PEERCRED")
+	}
+
+	credentials := &PeerCredentials{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}
+
+	// NOTE: The PID is 0 when the peer is in another PID namespace.
+	if ucred.Pid > 0 {
+		executable, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", ucred.Pid))
+		if err == nil {
+			credentials.Executable = executable
+		}
+
+		groups, err := readSupplementaryGroups(ucred.Pid)
+		if err == nil {
+			credentials.Groups = groups
+		}
+	}
+
+	return credentials, nil
+}
+
+// readSupplementaryGroups parses the `Groups:` line of /proc/<pid>/status.
+func readSupplementaryGroups(pid int32) ([]uint32, error) {
+	fd, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+
+		var groups []uint32
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, stacktrace.Propagate(err, "invalid group %s in /proc/%d/status", field, pid)
+			}
+
+			groups = append(groups, uint32(gid))
+		}
+
+		return groups, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, stacktrace.NewError("no groups in /proc/%d/status", pid)
+}
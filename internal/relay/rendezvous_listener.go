@@ -0,0 +1,185 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+)
+
+var errRendezvousListenerClosed = stacktrace.NewError("rendezvous listener is closed")
+
+// rendezvousListener is a `net.Listener` that instead of listening
+// keeps `idle` connections dialed to a rendezvous endpoint.
+// A tunnel connection is accepted once the rendezvous side sends its first bytes,
+// or `handshake` if set, and is replaced by a newly dialed one right away.
+type rendezvousListener struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	address   string
+	handshake []byte
+	dial      func(context.Context) (net.Conn, error)
+	logger    logger.Logger
+	ready     chan net.Conn
+	wg        sync.WaitGroup
+}
+
+func newRendezvousListener(
+	ctx context.Context,
+	logger logger.Logger,
+	address string,
+	idle int,
+	handshake []byte,
+	dial func(context.Context) (net.Conn, error),
+) *rendezvousListener {
+	ctx, cancel := context.WithCancel(ctx)
+
+	l := &rendezvousListener{
+		ctx:       ctx,
+		cancel:    cancel,
+		address:   address,
+		handshake: handshake,
+		dial:      dial,
+		logger:    logger,
+		ready:     make(chan net.Conn),
+	}
+
+	l.wg.Add(idle)
+	for i := 0; i < idle; i++ {
+		go l.maintainTunnel()
+	}
+
+	return l
+}
+
+// maintainTunnel keeps one idle tunnel open at all times.
+func (l *rendezvousListener) maintainTunnel() {
+	defer l.wg.Done()
+
+	backoff := redialMinBackoff
+	for l.ctx.Err() == nil {
+		conn, err := l.awaitTunnel()
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return
+			}
+
+			logging.WithError(l.logger, err).Debugf("Idle tunnel to %s failed, redialing in %s", l.address, backoff)
+
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		backoff = redialMinBackoff
+
+		select {
+		case l.ready <- conn:
+		case <-l.ctx.Done():
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// awaitTunnel dials the rendezvous endpoint and waits for the handshake or first bytes of a paired client.
+func (l *rendezvousListener) awaitTunnel() (net.Conn, error) {
+	conn, err := l.dial(l.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.logger.Debugf("Opened idle tunnel to %s from %s", l.address, conn.LocalAddr())
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// NOTE: Unblock the read below when the listener is closed.
+	go func() {
+		select {
+		case <-l.ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if len(l.handshake) > 0 {
+		received := make([]byte, len(l.handshake))
+		_, err = io.ReadFull(conn, received)
+		if err != nil {
+			_ = conn.Close()
+			return nil, stacktrace.Propagate(err, "idle tunnel closed before use")
+		}
+
+		if !bytes.Equal(received, l.handshake) {
+			_ = conn.Close()
+			return nil, stacktrace.NewError("unexpected tunnel handshake %q from %s", received, l.address)
+		}
+
+		return conn, nil
+	}
+
+	buffer := make([]byte, 4096)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		_ = conn.Close()
+		return nil, stacktrace.Propagate(err, "idle tunnel closed before use")
+	}
+
+	return &peekedConn{Conn: conn, peeked: buffer[:n]}, nil
+}
+
+func (l *rendezvousListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case <-l.ctx.Done():
+		return nil, errRendezvousListenerClosed
+	}
+}
+
+func (l *rendezvousListener) Close() error {
+	l.cancel()
+	l.wg.Wait()
+	return nil
+}
+
+func (l *rendezvousListener) Addr() net.Addr {
+	return rendezvousAddr(l.address)
+}
+
+type rendezvousAddr string
+
+func (a rendezvousAddr) Network() string {
+	return "tcp"
+}
+
+func (a rendezvousAddr) String() string {
+	return string(a)
+}
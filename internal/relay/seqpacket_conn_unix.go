@@ -0,0 +1,51 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package relay
+
+import (
+	"net"
+	"syscall"
+)
+
+// seqPacketConn fails reads of packets larger than the read buffer,
+// instead of silently relaying their truncated start.
+type seqPacketConn struct {
+	*net.UnixConn
+}
+
+func newSeqPacketConn(conn net.Conn) net.Conn {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn
+	}
+
+	return &seqPacketConn{UnixConn: unixConn}
+}
+
+func (c *seqPacketConn) Read(p []byte) (int, error) {
+	n, _, flags, _, err := c.ReadMsgUnix(p, nil)
+	if err != nil {
+		return n, err
+	}
+
+	if flags&syscall.MSG_TRUNC != 0 {
+		return 0, errPacketTruncated
+	}
+
+	return n, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/recording"
+)
+
+// SessionRecorder records the data relayed by connections with its timing,
+// for `gocat replay` to play the client side against a target later.
+type SessionRecorder struct {
+	fd     io.WriteCloser
+	writer *recording.Writer
+	logger logger.Logger
+	// run tells the sessions of this process apart from the ones of previous processes appending to the file.
+	run string
+}
+
+// NewSessionRecorder appends the recorded sessions to the file at `path`.
+func NewSessionRecorder(path string, logger logger.Logger) (*SessionRecorder, error) {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to open recording file %s", path)
+	}
+
+	return &SessionRecorder{
+		fd:     fd,
+		writer: recording.NewWriter(fd),
+		logger: logger,
+		run:    fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405.000000000Z"), os.Getpid()),
+	}, nil
+}
+
+func (r *SessionRecorder) Close() error {
+	return r.fd.Close()
+}
+
+func (r *SessionRecorder) connection(id uint64, client string) *sessionRecording {
+	result := &sessionRecording{
+		recorder: r,
+		id:       id,
+	}
+	result.write(recording.EventOpen, client, nil)
+
+	return result
+}
+
+type sessionRecording struct {
+	recorder *SessionRecorder
+	id       uint64
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func (s *sessionRecording) clientData(data []byte) {
+	s.write(recording.EventClientData, "", data)
+}
+
+func (s *sessionRecording) sourceData(data []byte) {
+	s.write(recording.EventSourceData, "", data)
+}
+
+func (s *sessionRecording) close() {
+	s.write(recording.EventClose, "", nil)
+}
+
+func (s *sessionRecording) write(eventType recording.EventType, client string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed {
+		return
+	}
+
+	err := s.recorder.writer.Write(recording.Event{
+		Run:        s.recorder.run,
+		Connection: s.id,
+		Time:       time.Now(),
+		Type:       eventType,
+		Client:     client,
+		Data:       data,
+	})
+	if err != nil {
+		// NOTE: Log once per connection instead of for every chunk.
+		s.failed = true
+		logging.With(s.recorder.logger, logging.FieldConnection, s.id, logging.FieldError, err).Errorf("Could not record connection")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+	"github.com/sumup-oss/gocat/internal/metrics"
+)
+
+const (
+	redialMinBackoff         = 100 * time.Millisecond
+	redialMaxBackoff         = 10 * time.Second
+	sourcePoolValidationWait = time.Millisecond
+)
+
+// pooledConn is a pre-dialed source connection.
+// Data read while validating it is kept and returned first by `Read`.
+type pooledConn struct {
+	peekedConn
+	dialedAt time.Time
+}
+
+// sourcePool keeps up to `size` connections to the source open,
+// so accepted connections don't pay for dialing the source.
+type sourcePool struct {
+	dial      func(context.Context) (net.Conn, error)
+	size      int
+	maxIdle   time.Duration
+	logger    logger.Logger
+	metrics   metrics.Recorder
+	relayName string
+	conns     chan *pooledConn
+	slots     chan struct{}
+}
+
+func newSourcePool(
+	dial func(context.Context) (net.Conn, error),
+	size int,
+	maxIdle time.Duration,
+	logger logger.Logger,
+	recorder metrics.Recorder,
+	relayName string,
+) *sourcePool {
+	if size < 1 {
+		return nil
+	}
+
+	slots := make(chan struct{}, size)
+	for i := 0; i < size; i++ {
+		slots <- struct{}{}
+	}
+
+	return &sourcePool{
+		dial:      dial,
+		size:      size,
+		maxIdle:   maxIdle,
+		logger:    logger,
+		metrics:   recorder,
+		relayName: relayName,
+		conns:     make(chan *pooledConn, size),
+		slots:     slots,
+	}
+}
+
+// run replenishes the pool in the background until `ctx` is done.
+func (p *sourcePool) run(ctx context.Context) {
+	defer p.drain()
+
+	backoff := redialMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.slots:
+		}
+
+		conn, err := p.dial(ctx)
+		if err != nil {
+			p.slots <- struct{}{}
+			if ctx.Err() != nil {
+				return
+			}
+
+			logging.WithError(p.logger, err).Debugf("Could not pre-dial source of %s, retrying in %s", p.relayName, backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = nextBackoff(backoff)
+
+			continue
+		}
+
+		backoff = redialMinBackoff
+		p.conns <- &pooledConn{peekedConn: peekedConn{Conn: conn}, dialedAt: time.Now()}
+		p.reportIdle()
+	}
+}
+
+// get hands out a validated pre-dialed connection,
+// or dials a fresh one if none is available.
+func (p *sourcePool) get(ctx context.Context) (net.Conn, error) {
+	for {
+		select {
+		case conn := <-p.conns:
+			p.slots <- struct{}{}
+			p.reportIdle()
+
+			if p.validate(conn) {
+				p.metrics.IncrCounter("source_pool_hits_total", 1, metrics.NewTag("relay", p.relayName))
+				return conn, nil
+			}
+
+			_ = conn.Close()
+		default:
+			p.metrics.IncrCounter("source_pool_misses_total", 1, metrics.NewTag("relay", p.relayName))
+			return p.dial(ctx)
+		}
+	}
+}
+
+// validate makes sure the source did not close the connection while it was idle.
+func (p *sourcePool) validate(conn *pooledConn) bool {
+	if p.maxIdle > 0 && time.Since(conn.dialedAt) > p.maxIdle {
+		p.logger.Debugf("Discarding pre-dialed source connection of %s idle since %s", p.relayName, conn.dialedAt)
+		return false
+	}
+
+	closed, ok := peekClosed(conn.Conn)
+	if ok {
+		if closed {
+			p.logger.Debugf("Discarding closed pre-dialed source connection of %s", p.relayName)
+		}
+
+		return !closed
+	}
+
+	// NOTE: Without a socket to peek at, a short read deadline distinguishes an open, silent connection (timeout)
+	// from a closed one (EOF/error). Anything read is kept for the client.
+	err := conn.Conn.SetReadDeadline(time.Now().Add(sourcePoolValidationWait))
+	if err != nil {
+		return false
+	}
+
+	var buffer [1]byte
+	n, err := conn.Conn.Read(buffer[:])
+	conn.peeked = append(conn.peeked, buffer[:n]...)
+
+	if err != nil {
+		netErr, ok := err.(net.Error)
+		if !ok || !netErr.Timeout() {
+			logging.WithError(p.logger, err).Debugf("Discarding closed pre-dialed source connection of %s", p.relayName)
+			return false
+		}
+	}
+
+	return conn.Conn.SetReadDeadline(time.Time{}) == nil
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > redialMaxBackoff {
+		return redialMaxBackoff
+	}
+
+	return backoff
+}
+
+func (p *sourcePool) drain() {
+	for {
+		select {
+		case conn := <-p.conns:
+			_ = conn.Close()
+		default:
+			p.reportIdle()
+			return
+		}
+	}
+}
+
+func (p *sourcePool) reportIdle() {
+	p.metrics.SetGauge("source_pool_idle", float64(len(p.conns)), metrics.NewTag("relay", p.relayName))
+}
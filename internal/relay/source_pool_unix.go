@@ -0,0 +1,56 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package relay
+
+import (
+	"net"
+	"syscall"
+)
+
+// peekClosed reports whether the peer closed `conn`, without blocking or consuming any data.
+// `ok` is false when `conn` has no socket to peek at, e.g a TLS connection.
+func peekClosed(conn net.Conn) (closed, ok bool) {
+	syscallConn, isSyscallConn := conn.(syscall.Conn)
+	if !isSyscallConn {
+		return false, false
+	}
+
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+
+	var buffer [1]byte
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buffer[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case err == syscall.EAGAIN || err == syscall.EWOULDBLOCK:
+			// NOTE: Open and silent.
+		case err != nil || n == 0:
+			closed = true
+		}
+
+		// NOTE: Never wait for the connection to become readable.
+		return true
+	})
+	if err != nil {
+		return true, true
+	}
+
+	return closed, true
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+)
+
+type SourceResolveMode string
+
+const (
+	// SourceResolveStatic dials the configured `<addr>:<port>` as-is,
+	// leaving resolution to the system on every dial.
+	SourceResolveStatic SourceResolveMode = "static"
+	// SourceResolveDNS resolves all A/AAAA records of the host periodically
+	// and spreads connections across them.
+	SourceResolveDNS SourceResolveMode = "dns"
+	// SourceResolveSRV looks up a SRV record, e.g `_ssh._tcp.example`, periodically
+	// and spreads connections across the A/AAAA records of its highest-priority targets.
+	SourceResolveSRV SourceResolveMode = "srv"
+)
+
+func ParseSourceResolveMode(value string) (SourceResolveMode, error) {
+	switch mode := SourceResolveMode(value); mode {
+	case SourceResolveStatic, SourceResolveDNS, SourceResolveSRV:
+		return mode, nil
+	default:
+		return "", stacktrace.NewError(
+			"invalid source resolve mode %s. Expected one of %s, %s, %s",
+			value,
+			SourceResolveStatic,
+			SourceResolveDNS,
+			SourceResolveSRV,
+		)
+	}
+}
+
+func newNetResolver(dnsServer string) *net.Resolver {
+	if dnsServer == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, dnsServer)
+		},
+	}
+}
+
+// tcpSourceResolver turns a TCP source into the address to dial next.
+// Resolved addresses are refreshed every `interval` in the background and handed out round-robin.
+type tcpSourceResolver struct {
+	logger   logger.Logger
+	mode     SourceResolveMode
+	address  string
+	interval time.Duration
+	resolver *net.Resolver
+
+	mu        sync.Mutex
+	addresses []string
+	next      int
+}
+
+func newTCPSourceResolver(
+	logger logger.Logger,
+	mode SourceResolveMode,
+	address string,
+	interval time.Duration,
+	resolver *net.Resolver,
+) *tcpSourceResolver {
+	return &tcpSourceResolver{
+		logger:   logger,
+		mode:     mode,
+		address:  address,
+		interval: interval,
+		resolver: resolver,
+	}
+}
+
+// run re-resolves the source every `interval` until `ctx` is done.
+func (s *tcpSourceResolver) run(ctx context.Context) {
+	if s.mode == SourceResolveStatic {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = s.refresh(ctx)
+		}
+	}
+}
+
+func (s *tcpSourceResolver) nextAddress(ctx context.Context) (string, error) {
+	if s.mode == SourceResolveStatic {
+		return s.address, nil
+	}
+
+	s.mu.Lock()
+	resolved := len(s.addresses) > 0
+	s.mu.Unlock()
+
+	// NOTE: Dials before the first successful resolution resolve on their own.
+	if !resolved {
+		err := s.refresh(ctx)
+		if err != nil {
+			return "", stacktrace.Propagate(err, "could not resolve %s", s.address)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address := s.addresses[s.next%len(s.addresses)]
+	s.next++
+	return address, nil
+}
+
+// refresh resolves the source without holding the lock, then swaps the resolved addresses in.
+// Previously resolved addresses are kept if resolution fails.
+func (s *tcpSourceResolver) refresh(ctx context.Context) error {
+	addresses, err := s.resolve(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.addresses = addresses
+		return nil
+	}
+
+	if len(s.addresses) < 1 {
+		return err
+	}
+
+	logging.WithError(s.logger, err).Warnf(
+		"Could not re-resolve %s, keeping %d previously resolved addresses",
+		s.address,
+		len(s.addresses),
+	)
+
+	return nil
+}
+
+func (s *tcpSourceResolver) resolve(ctx context.Context) ([]string, error) {
+	if s.mode == SourceResolveDNS {
+		host, port, err := net.SplitHostPort(s.address)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "could not split host and port of %s", s.address)
+		}
+
+		return s.lookupHost(ctx, host, port)
+	}
+
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.address)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to lookup SRV record %s", s.address)
+	}
+
+	if len(records) < 1 {
+		return nil, stacktrace.NewError("no SRV records found for %s", s.address)
+	}
+
+	var result []string
+	// NOTE: Records are sorted by priority, only the highest priority (lowest value) ones are used.
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+
+		addresses, err := s.lookupHost(
+			ctx,
+			strings.TrimSuffix(record.Target, "."),
+			strconv.Itoa(int(record.Port)),
+		)
+		if err != nil {
+			logging.WithError(s.logger, err).Warnf("Skipping SRV target %s of %s", record.Target, s.address)
+			continue
+		}
+
+		result = append(result, addresses...)
+	}
+
+	if len(result) < 1 {
+		return nil, stacktrace.NewError("no SRV target of %s could be resolved", s.address)
+	}
+
+	return result, nil
+}
+
+func (s *tcpSourceResolver) lookupHost(ctx context.Context, host, port string) ([]string, error) {
+	ipAddrs, err := s.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "failed to lookup A/AAAA records of %s", host)
+	}
+
+	result := make([]string, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		result = append(result, net.JoinHostPort(ipAddr.String(), port))
+	}
+
+	return result, nil
+}
@@ -37,25 +37,88 @@ func NewTCPtoUnixSocket(
 	tcpAddress,
 	unixSocketPath string,
 	bufferSize int,
+	opts ...Option,
 ) (*TCPtoUnixsocket, error) {
-	tcpAddressParts := strings.Split(tcpAddress, ":")
-	if len(tcpAddressParts) != 2 {
-		return nil, stacktrace.NewError(
-			"wrong format for tcp address %s. Expected <addr>:<port>",
-			tcpAddress,
-		)
+	relayOptions := newOptions(opts)
+
+	err := validateUnixSocketPath(unixSocketPath)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := strconv.ParseInt(tcpAddressParts[1], 10, 32)
+	err = relayOptions.validateFraming()
 	if err != nil {
-		return nil, stacktrace.Propagate(
-			err,
-			"could not parse specified port number %s",
-			tcpAddressParts[1],
+		return nil, err
+	}
+
+	// NOTE: SRV records carry the port, so the source is just the record name.
+	if relayOptions.sourceResolveMode != SourceResolveSRV {
+		tcpAddressParts := strings.Split(tcpAddress, ":")
+		if len(tcpAddressParts) != 2 {
+			return nil, stacktrace.NewError(
+				"wrong format for tcp address %s. Expected <addr>:<port>",
+				tcpAddress,
+			)
+		}
+
+		_, err := strconv.ParseInt(tcpAddressParts[1], 10, 32)
+		if err != nil {
+			return nil, stacktrace.Propagate(
+				err,
+				"could not parse specified port number %s",
+				tcpAddressParts[1],
+			)
+		}
+	}
+
+	netResolver := newNetResolver(relayOptions.dnsServer)
+	sourceResolver := newTCPSourceResolver(
+		logger,
+		relayOptions.sourceResolveMode,
+		tcpAddress,
+		relayOptions.sourceResolveInterval,
+		netResolver,
+	)
+
+	dialSourceConn := func(ctx context.Context) (net.Conn, error) {
+		address, err := sourceResolver.nextAddress(ctx)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed to resolve TCP source: %s", tcpAddress)
+		}
+
+		dialer := &net.Dialer{
+			KeepAlive: tcpKeepAlivePeriod,
+			Resolver:  netResolver,
+		}
+		conn, err := dialer.DialContext(
+			ctx,
+			"tcp",
+			address,
 		)
+		if err != nil {
+			return nil, stacktrace.Propagate(
+				err,
+				"failed to dial TCP address: %s",
+				address,
+			)
+		}
+
+		tcpConn := conn.(*net.TCPConn)
+		// TODO: Re-evaluate if this is redundant when `KeepAlive` and `net.Dialer` is used.
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(tcpKeepAlivePeriod)
+		return tcpConn, nil
+	}
+
+	if relayOptions.multiplex {
+		if relayOptions.multiplexTLS != nil {
+			dialSourceConn = dialTLS(dialSourceConn, tlsConfigWithServerName(relayOptions.multiplexTLS, tcpAddress))
+		}
+
+		dialSourceConn = newMuxDialer(logger, tcpAddress, dialSourceConn).open
 	}
 
-	return &TCPtoUnixsocket{
+	result := &TCPtoUnixsocket{
 		AbstractDuplexRelay{
 			healthCheckInterval: healthCheckInterval,
 			logger:              logger,
@@ -63,34 +126,12 @@ func NewTCPtoUnixSocket(
 			destinationName:     "unix socket",
 			destinationAddr:     unixSocketPath,
 			bufferSize:          bufferSize,
-			dialSourceConn: func(ctx context.Context) (net.Conn, error) {
-				dialer := &net.Dialer{
-					KeepAlive: tcpKeepAlivePeriod,
-				}
-				conn, err := dialer.DialContext(
-					ctx,
-					"tcp",
-					tcpAddress,
-				)
-				if err != nil {
-					return nil, stacktrace.Propagate(
-						err,
-						"failed to dial TCP address: %s",
-						tcpAddress,
-					)
-				}
-
-				tcpConn := conn.(*net.TCPConn)
-				// TODO: Re-evaluate if this is redundant when `KeepAlive` and `net.Dialer` is used.
-				_ = tcpConn.SetKeepAlive(true)
-				_ = tcpConn.SetKeepAlivePeriod(tcpKeepAlivePeriod)
-				return tcpConn, nil
-			},
+			dialSourceConn:      dialSourceConn,
+			sourceResolver:      sourceResolver,
 			listenTargetConn: func(ctx context.Context) (net.Listener, error) {
 				// NOTE: This is a streaming unix domain socket
 				// equivalent of `sock.STREAM`.
-				var lc net.ListenConfig
-				listener, err := lc.Listen(ctx, "unix", unixSocketPath)
+				listener, err := listenUnixSocket(ctx, logger, unixSocketPath, relayOptions)
 				if err != nil {
 					return nil, stacktrace.Propagate(
 						err,
@@ -101,5 +142,10 @@ func NewTCPtoUnixSocket(
 				return listener, nil
 			},
 		},
-	}, nil
+	}
+	result.configure(relayOptions, "tcp-to-unix")
+	result.frameSource = relayOptions.lengthPrefixFraming
+	result.seqPacketDestination = relayOptions.seqPacket
+
+	return result, nil
 }
@@ -0,0 +1,159 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+const trafficDumpTimeFormat = "2006/01/02 15:04:05.000000"
+
+// TrafficDump configures dumping relayed data, similar to `socat -v -x`.
+type TrafficDump struct {
+	// Writer receives the dump.
+	Writer io.Writer
+	// MaxChunkBytes caps the dumped bytes of each chunk. Unlimited if 0.
+	MaxChunkBytes int
+	// MaxConnectionBytes caps the dumped bytes of each connection and direction. Unlimited if 0.
+	MaxConnectionBytes int
+	// ConnectionIDs restricts the dump to these connections, if not empty.
+	ConnectionIDs []uint64
+	// ClientCIDRs restricts the dump to clients from these CIDRs (or single IPs), if not empty.
+	ClientCIDRs []string
+}
+
+// TrafficDumper dumps the data relayed by connections.
+type TrafficDumper struct {
+	config        TrafficDump
+	connectionIDs map[uint64]bool
+	clientNets    []*net.IPNet
+
+	mu sync.Mutex
+}
+
+func NewTrafficDumper(config TrafficDump) (*TrafficDumper, error) {
+	clientNets, err := parseCIDRs(config.ClientCIDRs)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "invalid traffic dump client filter")
+	}
+
+	connectionIDs := make(map[uint64]bool, len(config.ConnectionIDs))
+	for _, id := range config.ConnectionIDs {
+		connectionIDs[id] = true
+	}
+
+	return &TrafficDumper{
+		config:        config,
+		connectionIDs: connectionIDs,
+		clientNets:    clientNets,
+	}, nil
+}
+
+// connection returns the dump of a connection, or nil if the connection is filtered out.
+func (d *TrafficDumper) connection(id uint64, conn net.Conn, client string) *connectionDump {
+	if len(d.connectionIDs) > 0 && !d.connectionIDs[id] {
+		return nil
+	}
+
+	if len(d.clientNets) > 0 {
+		ip := remoteIP(conn.RemoteAddr())
+		if ip == nil || !containsIP(d.clientNets, ip) {
+			return nil
+		}
+	}
+
+	return &connectionDump{
+		dumper: d,
+		id:     id,
+		client: client,
+	}
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type connectionDump struct {
+	dumper *TrafficDumper
+	id     uint64
+	client string
+
+	mu            sync.Mutex
+	sentBytes     int
+	receivedBytes int
+}
+
+// dump writes `data` read in `direction`, `>` from the client to the source or `<` the other way around.
+func (c *connectionDump) dump(direction byte, data []byte) {
+	counter := &c.receivedBytes
+	if direction == '>' {
+		counter = &c.sentBytes
+	}
+
+	c.mu.Lock()
+	offset := *counter
+	*counter += len(data)
+	c.mu.Unlock()
+
+	dumped := data
+	config := c.dumper.config
+	if config.MaxConnectionBytes > 0 {
+		remaining := config.MaxConnectionBytes - offset
+		if remaining <= 0 {
+			return
+		}
+
+		if len(dumped) > remaining {
+			dumped = dumped[:remaining]
+		}
+	}
+
+	if config.MaxChunkBytes > 0 && len(dumped) > config.MaxChunkBytes {
+		dumped = dumped[:config.MaxChunkBytes]
+	}
+
+	header := fmt.Sprintf(
+		"%c %s conn=%d client=%s length=%d from=%d to=%d",
+		direction,
+		time.Now().Format(trafficDumpTimeFormat),
+		c.id,
+		c.client,
+		len(data),
+		offset,
+		offset+len(data)-1,
+	)
+	if len(dumped) < len(data) {
+		header += fmt.Sprintf(" truncated=%d", len(data)-len(dumped))
+	}
+
+	// NOTE: Write each chunk at once, so chunks of concurrent connections don't interleave.
+	c.dumper.mu.Lock()
+	defer c.dumper.mu.Unlock()
+
+	_, _ = io.WriteString(config.Writer, header+"\n"+hex.Dump(dumped)+"--\n")
+}
@@ -0,0 +1,382 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+)
+
+const (
+	defaultSocketDirMode   os.FileMode = 0755
+	staleSocketDialTimeout             = time.Second
+)
+
+// UnixSocketPermissions configure the unix socket file a relay listens at.
+type UnixSocketPermissions struct {
+	// Mode of the socket file. The process umask applies if 0.
+	Mode os.FileMode
+	// Owner and Group of the socket file, as names or numeric IDs. Unchanged if empty.
+	Owner string
+	Group string
+	// CreateDir creates the missing parent directories with DirMode,
+	// owned by Owner and Group.
+	CreateDir bool
+	DirMode   os.FileMode
+}
+
+type unixSocketOwnership struct {
+	uid int
+	gid int
+}
+
+func (p *UnixSocketPermissions) ownership() (*unixSocketOwnership, error) {
+	result := &unixSocketOwnership{uid: -1, gid: -1}
+
+	if p.Owner != "" {
+		uid, err := lookupID(p.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "unknown socket owner %s", p.Owner)
+		}
+
+		result.uid = int(uid)
+	}
+
+	if p.Group != "" {
+		gid, err := lookupID(p.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "unknown socket group %s", p.Group)
+		}
+
+		result.gid = int(gid)
+	}
+
+	return result, nil
+}
+
+// isAbstractUnixSocket reports whether `path` is in the Linux abstract namespace, e.g `@name`.
+// Such sockets have no file, vanish with their listener and are reachable across mount namespaces.
+func isAbstractUnixSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+func validateUnixSocketPath(path string) error {
+	if isAbstractUnixSocket(path) && runtime.GOOS != "linux" {
+		return stacktrace.NewError("abstract unix socket %s is only supported on Linux", path)
+	}
+
+	return nil
+}
+
+// unixSocketListener removes its socket file and releases its lock file, if any, when closed.
+type unixSocketListener struct {
+	net.Listener
+	path      string
+	socket    os.FileInfo
+	lock      *os.File
+	closeOnce sync.Once
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		removeUnixSocket(l.path, l.socket)
+		unlockFile(l.lock)
+	})
+
+	return err
+}
+
+// removeUnixSocket removes the socket file at `path` unless it was replaced since bound as `socket`,
+// e.g by another instance after this one was considered stale.
+func removeUnixSocket(path string, socket os.FileInfo) {
+	current, err := os.Lstat(path)
+	if err != nil || !os.SameFile(current, socket) {
+		return
+	}
+
+	_ = os.Remove(path)
+}
+
+// listenUnixSocket listens at `path` once it's sure no other process is serving there.
+// A stale socket left behind, e.g by a crashed instance, is removed.
+func listenUnixSocket(ctx context.Context, logger logger.Logger, path string, o *options) (net.Listener, error) {
+	permissions := o.unixSocketPermissions
+	if isAbstractUnixSocket(path) {
+		if permissions != nil || o.unixSocketLock {
+			return nil, stacktrace.NewError(
+				"file permissions and lock files don't apply to abstract unix socket %s",
+				path,
+			)
+		}
+
+		// NOTE: Binding an abstract socket in use fails, and there's no file to clean up.
+		var lc net.ListenConfig
+		return lc.Listen(ctx, o.unixNetwork(), path)
+	}
+
+	if permissions != nil && permissions.CreateDir {
+		err := createSocketDir(filepath.Dir(path), permissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lock *os.File
+	if o.unixSocketLock {
+		var err error
+		lock, err = lockFile(path + ".lock")
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "could not lock %s.lock, is another gocat instance using it?", path)
+		}
+	}
+
+	err := claimUnixSocketPath(logger, o.unixNetwork(), path)
+	if err != nil {
+		unlockFile(lock)
+		return nil, err
+	}
+
+	listener, socket, err := bindUnixSocket(ctx, o.unixNetwork(), path, permissions)
+	if err != nil {
+		unlockFile(lock)
+		return nil, err
+	}
+
+	return &unixSocketListener{
+		Listener: listener,
+		path:     path,
+		socket:   socket,
+		lock:     lock,
+	}, nil
+}
+
+// ListenUnixSocket listens at the unix socket `path` created with `permissions`,
+// so it's never accessible with broader ones. A stale socket left behind is removed.
+func ListenUnixSocket(
+	ctx context.Context,
+	logger logger.Logger,
+	path string,
+	permissions *UnixSocketPermissions,
+) (net.Listener, error) {
+	return listenUnixSocket(ctx, logger, path, &options{unixSocketPermissions: permissions})
+}
+
+// claimUnixSocketPath makes sure nothing is served at `path`,
+// removing a socket nobody accepts connections on anymore.
+func claimUnixSocketPath(logger logger.Logger, network, path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return stacktrace.Propagate(err, "could not stat %s", path)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return stacktrace.NewError("%s exists and is not a unix socket, refusing to replace it", path)
+	}
+
+	conn, err := net.DialTimeout(network, path, staleSocketDialTimeout)
+	if err == nil {
+		_ = conn.Close()
+		return stacktrace.NewError("unix socket %s is in use by another process", path)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return stacktrace.Propagate(err, "could not determine whether unix socket %s is stale", path)
+	}
+
+	logger.Warnf("Removing stale unix socket %s", path)
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return stacktrace.Propagate(err, "could not remove stale unix socket %s", path)
+	}
+
+	return nil
+}
+
+func createSocketDir(dir string, permissions *UnixSocketPermissions) error {
+	ownership, err := permissions.ownership()
+	if err != nil {
+		return err
+	}
+
+	dirMode := permissions.DirMode
+	if dirMode == 0 {
+		dirMode = defaultSocketDirMode
+	}
+
+	err = createDir(dir, dirMode, ownership)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to create socket directory %s", dir)
+	}
+
+	return nil
+}
+
+// bindUnixSocket listens at `path`, applying `permissions` (if not nil)
+// before the socket becomes reachable at `path`.
+// It returns the bound socket file too, to only ever remove that one.
+func bindUnixSocket(
+	ctx context.Context,
+	network,
+	path string,
+	permissions *UnixSocketPermissions,
+) (net.Listener, os.FileInfo, error) {
+	var lc net.ListenConfig
+	if permissions == nil {
+		listener, err := lc.Listen(ctx, network, path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// NOTE: Unlinking is done by `unixSocketListener`.
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+		socket, err := os.Lstat(path)
+		if err != nil {
+			_ = listener.Close()
+			return nil, nil, stacktrace.Propagate(err, "could not stat unix socket %s", path)
+		}
+
+		return listener, socket, nil
+	}
+
+	ownership, err := permissions.ownership()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// NOTE: Bind in a private directory next to `path`, so nobody can connect
+	// until mode and ownership are applied and the socket is atomically linked into place.
+	dir := filepath.Dir(path)
+	privateDir, err := ioutil.TempDir(dir, ".gocat-")
+	if err != nil {
+		return nil, nil, stacktrace.Propagate(err, "failed to create temporary directory in %s", dir)
+	}
+	defer os.RemoveAll(privateDir)
+
+	privatePath := filepath.Join(privateDir, "sock")
+	listener, err := lc.Listen(ctx, network, privatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// NOTE: The socket is going to be at `path`, unlinking is done by `unixSocketListener`.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	socket, err := setUpPrivateUnixSocket(privatePath, path, permissions.Mode, ownership)
+	if err != nil {
+		_ = listener.Close()
+		return nil, nil, stacktrace.Propagate(err, "failed to set up unix socket at %s", path)
+	}
+
+	return listener, socket, nil
+}
+
+// setUpPrivateUnixSocket applies mode and ownership to the socket at `privatePath` and links it to `path`.
+// NOTE: Unlike renaming, linking fails if another process created `path` meanwhile, instead of replacing it.
+func setUpPrivateUnixSocket(
+	privatePath,
+	path string,
+	mode os.FileMode,
+	ownership *unixSocketOwnership,
+) (os.FileInfo, error) {
+	err := applySocketPermissions(privatePath, mode, ownership)
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := os.Lstat(privatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Link(privatePath, path)
+	if os.IsExist(err) {
+		return nil, stacktrace.NewError("%s was created by another process meanwhile", path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return socket, nil
+}
+
+func applySocketPermissions(path string, mode os.FileMode, ownership *unixSocketOwnership) error {
+	if ownership.uid != -1 || ownership.gid != -1 {
+		err := os.Chown(path, ownership.uid, ownership.gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mode != 0 {
+		return os.Chmod(path, mode)
+	}
+
+	return nil
+}
+
+func createDir(dir string, mode os.FileMode, ownership *unixSocketOwnership) error {
+	_, err := os.Stat(dir)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	err = createDir(filepath.Dir(dir), mode, ownership)
+	if err != nil {
+		return err
+	}
+
+	err = os.Mkdir(dir, mode)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	// NOTE: `os.Mkdir` is subject to the umask.
+	return applySocketPermissions(dir, mode, ownership)
+}
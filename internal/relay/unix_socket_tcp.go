@@ -16,6 +16,7 @@ package relay
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"os"
 	"strconv"
@@ -36,7 +37,20 @@ func NewUnixSocketTCP(
 	unixSocketPath,
 	tcpAddress string,
 	bufferSize int,
+	opts ...Option,
 ) (*UnixSocketTCP, error) {
+	relayOptions := newOptions(opts)
+
+	err := validateUnixSocketPath(unixSocketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = relayOptions.validateFraming()
+	if err != nil {
+		return nil, err
+	}
+
 	tcpAddressParts := strings.Split(tcpAddress, ":")
 	if len(tcpAddressParts) != 2 {
 		return nil, stacktrace.NewError(
@@ -45,7 +59,7 @@ func NewUnixSocketTCP(
 		)
 	}
 
-	_, err := strconv.ParseInt(tcpAddressParts[1], 10, 32)
+	_, err = strconv.ParseInt(tcpAddressParts[1], 10, 32)
 	if err != nil {
 		return nil, stacktrace.Propagate(
 			err,
@@ -54,12 +68,15 @@ func NewUnixSocketTCP(
 		)
 	}
 
-	_, err = os.Stat(unixSocketPath)
-	if os.IsNotExist(err) {
-		return nil, stacktrace.Propagate(err, "could not stat %s", unixSocketPath)
+	// NOTE: Abstract unix sockets have no file to stat.
+	if !isAbstractUnixSocket(unixSocketPath) {
+		_, err = os.Stat(unixSocketPath)
+		if os.IsNotExist(err) {
+			return nil, stacktrace.Propagate(err, "could not stat %s", unixSocketPath)
+		}
 	}
 
-	return &UnixSocketTCP{
+	result := &UnixSocketTCP{
 		AbstractDuplexRelay{
 			healthCheckInterval: healthCheckInterval,
 			logger:              logger,
@@ -70,8 +87,8 @@ func NewUnixSocketTCP(
 			dialSourceConn: func(ctx context.Context) (net.Conn, error) {
 				dialer := &net.Dialer{}
 				// NOTE: This is a streaming unix domain socket
-				// equivalent of `sock.STREAM`.
-				conn, err := dialer.DialContext(ctx, "unix", unixSocketPath)
+				// equivalent of `sock.STREAM`, unless it's a seqpacket one.
+				conn, err := dialer.DialContext(ctx, relayOptions.unixNetwork(), unixSocketPath)
 				if err != nil {
 					return nil, stacktrace.Propagate(
 						err,
@@ -92,8 +109,22 @@ func NewUnixSocketTCP(
 						tcpAddress,
 					)
 				}
-				return listener, nil
+
+				if !relayOptions.multiplex {
+					return listener, nil
+				}
+
+				if relayOptions.multiplexTLS != nil {
+					listener = tls.NewListener(listener, relayOptions.multiplexTLS)
+				}
+
+				return newMuxListener(listener, logger), nil
 			},
 		},
-	}, nil
+	}
+	result.configure(relayOptions, "unix-to-tcp")
+	result.frameDestination = relayOptions.lengthPrefixFraming
+	result.seqPacketSource = relayOptions.seqPacket
+
+	return result, nil
 }
@@ -0,0 +1,161 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSServer is a stand-in UDP DNS server answering A, AAAA and SRV questions
+// from records registered in-memory.
+type DNSServer struct {
+	t       TestingT
+	mu      sync.Mutex
+	records map[string][]dnsmessage.Resource
+}
+
+func NewDNSServer(t TestingT) *DNSServer {
+	return &DNSServer{
+		t:       t,
+		records: make(map[string][]dnsmessage.Resource),
+	}
+}
+
+func (ds *DNSServer) AddA(name string, ip net.IP) {
+	var a [4]byte
+	copy(a[:], ip.To4())
+
+	ds.add(name, dnsmessage.TypeA, &dnsmessage.AResource{A: a})
+}
+
+func (ds *DNSServer) AddSRV(name, target string, port, priority, weight uint16) {
+	ds.add(name, dnsmessage.TypeSRV, &dnsmessage.SRVResource{
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   dnsmessage.MustNewName(fqdn(target)),
+	})
+}
+
+// Remove removes all records of `name`, e.g to replace them.
+func (ds *DNSServer) Remove(name string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.records, fqdn(name))
+}
+
+func (ds *DNSServer) add(name string, recordType dnsmessage.Type, body dnsmessage.ResourceBody) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	key := fqdn(name)
+	ds.records[key] = append(ds.records[key], dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(key),
+			Type:  recordType,
+			Class: dnsmessage.ClassINET,
+			TTL:   1,
+		},
+		Body: body,
+	})
+}
+
+func (ds *DNSServer) Serve(started chan<- *ListenResult) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		started <- &ListenResult{
+			Err: err,
+		}
+
+		return
+	}
+	defer conn.Close()
+
+	addr := conn.LocalAddr().String()
+	host, port, err := net.SplitHostPort(addr)
+	started <- &ListenResult{
+		Address: addr,
+		Port:    port,
+		Host:    host,
+		Err:     err,
+	}
+
+	buffer := make([]byte, 512)
+	for {
+		n, remoteAddr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			ds.t.Logf("DNS read err: %s", err)
+			return
+		}
+
+		response, err := ds.answer(buffer[:n])
+		if err != nil {
+			ds.t.Logf("DNS answer err: %s", err)
+			continue
+		}
+
+		_, _ = conn.WriteTo(response, remoteAddr)
+	}
+}
+
+func (ds *DNSServer) answer(request []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	err := msg.Unpack(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            msg.Header.ID,
+			Response:      true,
+			Authoritative: true,
+		},
+		Questions: msg.Questions,
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, question := range msg.Questions {
+		records, ok := ds.records[strings.ToLower(question.Name.String())]
+		if !ok {
+			response.Header.RCode = dnsmessage.RCodeNameError
+			continue
+		}
+
+		for _, record := range records {
+			if record.Header.Type == question.Type {
+				response.Answers = append(response.Answers, record)
+			}
+		}
+	}
+
+	return response.Pack()
+}
+
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+
+	return name + "."
+}
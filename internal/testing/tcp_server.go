@@ -17,11 +17,14 @@ package testing
 import (
 	"io"
 	"net"
+	"sync/atomic"
 
 	"github.com/stretchr/testify/require"
 )
 
 type TCPServer struct {
+	// NOTE: First to be 64-bit aligned for atomic operations on 32-bit platforms.
+	accepted        int64
 	address         string
 	t               TestingT
 	msgsToBroadcast chan []byte
@@ -67,10 +70,16 @@ func (ts *TCPServer) Serve(started chan<- *ListenResult) {
 		c, err := ln.Accept()
 		require.Nil(ts.t, err, "Failed to accept connection")
 
+		atomic.AddInt64(&ts.accepted, 1)
 		go ts.handleConnection(c)
 	}
 }
 
+// Accepted returns the number of connections accepted so far.
+func (ts *TCPServer) Accepted() int64 {
+	return atomic.LoadInt64(&ts.accepted)
+}
+
 func (ts *TCPServer) handleConnection(c net.Conn) {
 	defer c.Close()
 
@@ -0,0 +1,362 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/sumup-oss/go-pkgs/logger"
+
+	"github.com/sumup-oss/gocat/internal/logging"
+)
+
+const (
+	defaultBatchSize     = 512
+	defaultQueueSize     = 4096
+	defaultFlushInterval = 5 * time.Second
+	defaultExportTimeout = 10 * time.Second
+
+	// NOTE: OTLP span kind and status code values.
+	spanKindServer  = 2
+	statusCodeOK    = 1
+	statusCodeError = 2
+)
+
+// ExporterConfig configures where and how spans are exported.
+type ExporterConfig struct {
+	// Endpoint of the collector, e.g `http://localhost:4318`. `/v1/traces` is appended if there's no path.
+	Endpoint    string
+	ServiceName string
+	// Headers are added to export requests, e.g for authentication.
+	Headers map[string]string
+	// FlushInterval is how often queued spans are exported, if fewer than a batch.
+	FlushInterval time.Duration
+}
+
+// Exporter exports spans in batches in the background, dropping them if the collector can't keep up.
+type Exporter struct {
+	endpoint      string
+	serviceName   string
+	headers       map[string]string
+	flushInterval time.Duration
+	client        *http.Client
+	logger        logger.Logger
+
+	queue     chan *Span
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewExporter(config ExporterConfig, logger logger.Logger) (*Exporter, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, stacktrace.NewError("invalid OTLP endpoint %s, expected http(s)://host:port", config.Endpoint)
+	}
+
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/v1/traces"
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "gocat"
+	}
+
+	flushInterval := config.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	exporter := &Exporter{
+		endpoint:      endpoint.String(),
+		serviceName:   serviceName,
+		headers:       config.Headers,
+		flushInterval: flushInterval,
+		client:        &http.Client{Timeout: defaultExportTimeout},
+		logger:        logger,
+		queue:         make(chan *Span, defaultQueueSize),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	go exporter.run()
+
+	return exporter, nil
+}
+
+// Export queues `span` for export, dropping it if the queue is full.
+func (e *Exporter) Export(span *Span) {
+	select {
+	case <-e.done:
+	case e.queue <- span:
+	default:
+		e.logger.Debugf("Dropping span %s, the OTLP export queue is full", span.SpanID)
+	}
+}
+
+// Close exports the queued spans and stops exporting.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.done)
+	})
+	<-e.stopped
+
+	return nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, defaultBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		err := e.export(batch)
+		if err != nil {
+			logging.WithError(e.logger, err).Warnf("Could not export %d spans to %s", len(batch), e.endpoint)
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= defaultBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+					if len(batch) >= defaultBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to encode spans")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultExportTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to create export request")
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return stacktrace.Propagate(err, "failed to send export request")
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return stacktrace.NewError("collector responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// NOTE: The OTLP/HTTP JSON encoding of `ExportTraceServiceRequest`,
+// with IDs in hex and 64-bit integers as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (e *Exporter) request(spans []*Span) *otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		encoded = append(encoded, encodeSpan(span))
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{encodeAttribute(Attribute{Key: "service.name", Value: e.serviceName})},
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "github.com/sumup-oss/gocat"},
+						Spans: encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+func encodeSpan(span *Span) otlpSpan {
+	result := otlpSpan{
+		TraceID:           span.TraceID.String(),
+		SpanID:            span.SpanID.String(),
+		Name:              span.Name,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: unixNano(span.Start),
+		EndTimeUnixNano:   unixNano(span.End),
+		Status:            otlpStatus{Code: statusCodeOK},
+	}
+
+	if span.Error != "" {
+		result.Status = otlpStatus{Code: statusCodeError, Message: span.Error}
+	}
+
+	for _, attribute := range span.Attributes {
+		result.Attributes = append(result.Attributes, encodeAttribute(attribute))
+	}
+
+	for _, event := range span.Events {
+		result.Events = append(result.Events, otlpEvent{
+			TimeUnixNano: unixNano(event.Time),
+			Name:         event.Name,
+		})
+	}
+
+	return result
+}
+
+func encodeAttribute(attribute Attribute) otlpAttribute {
+	var value otlpValue
+	switch typed := attribute.Value.(type) {
+	case bool:
+		value.BoolValue = &typed
+	case int:
+		intValue := strconv.Itoa(typed)
+		value.IntValue = &intValue
+	case int64:
+		intValue := strconv.FormatInt(typed, 10)
+		value.IntValue = &intValue
+	case uint64:
+		intValue := strconv.FormatUint(typed, 10)
+		value.IntValue = &intValue
+	case string:
+		value.StringValue = &typed
+	default:
+		stringValue := fmt.Sprint(typed)
+		value.StringValue = &stringValue
+	}
+
+	return otlpAttribute{Key: attribute.Key, Value: value}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// ParseHeaders parses `key=value` pairs.
+func ParseHeaders(pairs []string) (map[string]string, error) {
+	headers := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, stacktrace.NewError("invalid header %s, expected key=value", pair)
+		}
+
+		headers[strings.TrimSpace(parts[0])] = parts[1]
+	}
+
+	return headers, nil
+}
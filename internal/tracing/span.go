@@ -0,0 +1,75 @@
+// Copyright 2018 SumUp Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing exports spans to an OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Span is a finished operation, e.g a relayed connection.
+type Span struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Events     []Event
+	// Error marks the span as failed with this description, if set.
+	Error string
+}
+
+// Attribute is a key and a string, integer or boolean value.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Event is a point in time within a span.
+type Event struct {
+	Name string
+	Time time.Time
+}
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// NewTraceID returns a random trace ID.
+func NewTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func NewSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+
+	return id
+}
@@ -16,12 +16,14 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	log "github.com/sumup-oss/go-pkgs/logger"
 	"github.com/sumup-oss/go-pkgs/os"
 
 	"github.com/sumup-oss/gocat/cmd"
 	"github.com/sumup-oss/gocat/internal/config"
+	"github.com/sumup-oss/gocat/internal/logging"
 )
 
 func main() {
@@ -33,25 +35,48 @@ func main() {
 		osExecutor.Exit(1)
 	}
 
-	switch configInstance.LogLevel {
-	case "DEBUG":
-		log.SetLevel(log.DebugLevel)
-	case "ERROR":
-		log.SetLevel(log.ErrorLevel)
-	case "FATAL":
-		log.SetLevel(log.FatalLevel)
-	case "INFO":
-		log.SetLevel(log.InfoLevel)
-	case "PANIC":
-		log.SetLevel(log.PanicLevel)
-	case "WARN":
-		log.SetLevel(log.WarnLevel)
-	default:
-		log.Fatalf("invalid log level %s. Make sure it's upper-case", configInstance.LogLevel)
+	level, err := logging.ParseLevel(configInstance.LogLevel)
+	if err != nil {
+		//nolint:errcheck,staticcheck
+		fmt.Fprintf(osExecutor.Stderr(), err.Error())
+		osExecutor.Exit(1)
+	}
+
+	format, err := logging.ParseFormat(configInstance.LogFormat)
+	if err != nil {
+		//nolint:errcheck,staticcheck
+		fmt.Fprintf(osExecutor.Stderr(), err.Error())
+		osExecutor.Exit(1)
+	}
+
+	sinkConfig := logging.SinkConfig{
+		Sink:           configInstance.LogSink,
+		Format:         format,
+		Stderr:         osExecutor.Stderr(),
+		SyslogAddress:  configInstance.SyslogAddress,
+		SyslogFacility: configInstance.SyslogFacility,
+		SyslogCAFile:   configInstance.SyslogCAFile,
+		JournaldSocket: configInstance.JournaldSocket,
+	}
+
+	sink, err := logging.NewSink(sinkConfig)
+	if err != nil {
+		//nolint:errcheck,staticcheck
+		fmt.Fprintf(osExecutor.Stderr(), err.Error())
+		osExecutor.Exit(1)
+	}
+
+	logger := logging.New(sink, level)
+	log.SetLogger(logger)
+
+	err = cmd.NewRootCmd(osExecutor, logger, sinkConfig).Execute()
+
+	// NOTE: Sends the entries still queued by the syslog sink.
+	closer, ok := logger.Sink().(io.Closer)
+	if ok {
+		_ = closer.Close()
 	}
 
-	logger := log.GetLogger()
-	err = cmd.NewRootCmd(osExecutor, logger).Execute()
 	if err == nil {
 		return
 	}
@@ -16,18 +16,32 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/sumup-oss/go-pkgs/os"
 	"github.com/sumup-oss/go-pkgs/task"
 	"github.com/sumup-oss/go-pkgs/testutils"
+	"github.com/sumup-oss/gocat/internal/relay"
 	gocatTesting "github.com/sumup-oss/gocat/internal/testing"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	stdOs "os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -121,6 +135,2415 @@ func TestGocatUnixToTCP(t *testing.T) {
 	)
 }
 
+func TestGocatTCPToUnixSRVResolution(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	srcPort, err := strconv.ParseUint(testSrcServerListenResult.Port, 10, 16)
+	require.Nil(t, err, "Failed to parse TCP src server port")
+
+	dnsServer := gocatTesting.NewDNSServer(t)
+	dnsServer.AddSRV("_echo._tcp.gocat.test", "echo.gocat.test", uint16(srcPort), 10, 10)
+	dnsServer.AddA("echo.gocat.test", net.ParseIP("127.0.0.1"))
+	dnsListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go dnsServer.Serve(dnsListenCh)
+	dnsListenResult := <-dnsListenCh
+	require.Nil(t, dnsListenResult.Err, "Failed to listen with DNS server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-tcp-to-unix-srv-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		"_echo._tcp.gocat.test",
+		"--src-resolve",
+		"srv",
+		"--dns-server",
+		dnsListenResult.Address,
+		"--dst",
+		dstListenAddress,
+	)
+
+	dstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+}
+
+func TestGocatTCPToUnixDNSResolution(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	dnsServer := gocatTesting.NewDNSServer(t)
+	dnsServer.AddA("echo.gocat.test", net.ParseIP("127.0.0.1"))
+	dnsListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go dnsServer.Serve(dnsListenCh)
+	dnsListenResult := <-dnsListenCh
+	require.Nil(t, dnsListenResult.Err, "Failed to listen with DNS server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-tcp-to-unix-dns-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		net.JoinHostPort("echo.gocat.test", testSrcServerListenResult.Port),
+		"--src-resolve",
+		"dns",
+		"--dns-server",
+		dnsListenResult.Address,
+		"--dst",
+		dstListenAddress,
+	)
+
+	dstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+}
+
+func TestGocatTCPToUnixSRVReResolution(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	serveSource := func() (*gocatTesting.TCPServer, uint16) {
+		server := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+		listenCh := make(chan *gocatTesting.ListenResult, 1)
+		go server.Serve(listenCh)
+		listenResult := <-listenCh
+		require.Nil(t, listenResult.Err, "Failed to listen with TCP src server")
+
+		port, err := strconv.ParseUint(listenResult.Port, 10, 16)
+		require.Nil(t, err, "Failed to parse TCP src server port")
+
+		return server, uint16(port)
+	}
+
+	firstSrcServer, firstPort := serveSource()
+	secondSrcServer, secondPort := serveSource()
+
+	dnsServer := gocatTesting.NewDNSServer(t)
+	dnsServer.AddSRV("_echo._tcp.gocat.test", "echo.gocat.test", firstPort, 10, 10)
+	dnsServer.AddA("echo.gocat.test", net.ParseIP("127.0.0.1"))
+	dnsListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go dnsServer.Serve(dnsListenCh)
+	dnsListenResult := <-dnsListenCh
+	require.Nil(t, dnsListenResult.Err, "Failed to listen with DNS server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-tcp-to-unix-srv-re-resolution-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		"_echo._tcp.gocat.test",
+		"--src-resolve",
+		"srv",
+		"--src-resolve-interval",
+		"100ms",
+		"--health-check-interval",
+		"100ms",
+		"--dns-server",
+		dnsListenResult.Address,
+		"--dst",
+		dstListenAddress,
+	)
+
+	firstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer firstClient.Close()
+	assertEcho(t, firstClient, payload)
+	assert.Greater(t, firstSrcServer.Accepted(), int64(0))
+	assert.Equal(t, int64(0), secondSrcServer.Accepted())
+
+	dnsServer.Remove("_echo._tcp.gocat.test")
+	dnsServer.AddSRV("_echo._tcp.gocat.test", "echo.gocat.test", secondPort, 10, 10)
+
+	// NOTE: Health checks dial the source too, so they reach the second one once re-resolved.
+	require.Eventually(
+		t,
+		func() bool { return secondSrcServer.Accepted() > 0 },
+		5*time.Second,
+		50*time.Millisecond,
+		"Expected the re-resolved source to be dialed",
+	)
+
+	accepted := firstSrcServer.Accepted()
+	secondClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer secondClient.Close()
+	assertEcho(t, secondClient, payload)
+	assert.Equal(t, accepted, firstSrcServer.Accepted(), "Expected the previous source not to be dialed anymore")
+}
+
+func TestGocatUnixToTCPCircuitBreaker(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	srcSocket := tempUnixSocketPath(t, "gocat-circuit-breaker-src")
+
+	freeAddress := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err, "Failed to create temporary address")
+		defer l.Close()
+
+		return l.Addr().String()
+	}
+
+	srcListener, _ := serveUnixEchoSource(t, srcSocket)
+	dstListenAddress := freeAddress()
+	metricsListenAddress := freeAddress()
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcSocket,
+		"--dst",
+		dstListenAddress,
+		"--health-check-interval",
+		"1h",
+		"--circuit-breaker-failures",
+		"2",
+		"--circuit-breaker-cooldown",
+		"1s",
+		"--metrics-listen",
+		metricsListenAddress,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	// NOTE: Closing the source listener removes its socket, making the source dials fail.
+	err := srcListener.Close()
+	require.Nil(t, err, "Failed to close Unix socket src server")
+
+	// NOTE: 2 failed dials open the breaker, the 3rd connection is rejected without dialing.
+	for i := 0; i < 3; i++ {
+		client, err := gocatTesting.NewTCPClient(dstListenAddress)
+		require.Nil(t, err, "Failed to connect to gocat dst address")
+
+		_, err = client.SendMsg(payload)
+		if err == nil {
+			_, err = client.ReceiveMsg(len(payload))
+		}
+		assert.NotNil(t, err, "Expected connection to be closed without a source")
+		client.Close()
+	}
+
+	scrape := func() string {
+		response, err := http.Get("http://" + metricsListenAddress + "/metrics")
+		require.Nil(t, err, "Failed to scrape metrics")
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		require.Nil(t, err, "Failed to read metrics")
+
+		return string(body)
+	}
+
+	metricsOutput := scrape()
+	assert.Contains(t, metricsOutput, "# TYPE gocat_circuit_breaker_state gauge\n")
+	assert.Contains(t, metricsOutput, `gocat_circuit_breaker_state{relay="unix-to-tcp"} 2`+"\n")
+	assert.Contains(t, metricsOutput, "# TYPE gocat_source_dial_failures_total counter\n")
+	assert.Contains(t, metricsOutput, `gocat_source_dial_failures_total{relay="unix-to-tcp"} 2`+"\n")
+	assert.Contains(t, metricsOutput, `gocat_circuit_breaker_rejections_total{relay="unix-to-tcp"} 1`+"\n")
+	assert.Contains(t, metricsOutput, `gocat_connections_rejected_total{relay="unix-to-tcp",reason="circuit_open"} 1`+"\n")
+
+	srcListener, _ = serveUnixEchoSource(t, srcSocket)
+	defer srcListener.Close()
+
+	// NOTE: After the cooldown, the half-open breaker lets a probe dial through, which closes it.
+	time.Sleep(1100 * time.Millisecond)
+
+	dstClient = waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+	assertEcho(t, dstClient, payload)
+
+	assert.Contains(t, scrape(), `gocat_circuit_breaker_state{relay="unix-to-tcp"} 0`+"\n")
+}
+
+func TestGocatUnixToTCPSourcePool(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	srcSocket := tempUnixSocketPath(t, "gocat-source-pool-src")
+	srcListener, accepted := serveUnixEchoSource(t, srcSocket)
+	defer srcListener.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcSocket,
+		"--dst",
+		dstListenAddress,
+		"--health-check-interval",
+		"1h",
+		"--src-pool-size",
+		"2",
+	)
+
+	// NOTE: The initial health check and the 2 pre-dialed connections.
+	require.Eventually(
+		t,
+		func() bool { return accepted() == 3 },
+		5*time.Second,
+		10*time.Millisecond,
+		"Expected the source pool to be filled",
+	)
+
+	// NOTE: Without a listening source, only a pre-dialed connection can serve the client.
+	err = srcListener.Close()
+	require.Nil(t, err, "Failed to close Unix socket src server")
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+	assert.Equal(t, int64(3), accepted())
+}
+
+func TestGocatConnectConnect(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	rendezvousListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to listen with rendezvous server")
+	defer rendezvousListener.Close()
+
+	runGocat(
+		ctx,
+		"connect-connect",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		rendezvousListener.Addr().String(),
+		"--idle-tunnels",
+		"2",
+	)
+
+	acceptTunnel := func() net.Conn {
+		err := rendezvousListener.(*net.TCPListener).SetDeadline(time.Now().Add(30 * time.Second))
+		require.Nil(t, err)
+
+		conn, err := rendezvousListener.Accept()
+		require.Nil(t, err, "Failed to accept tunnel from gocat")
+
+		return relay.NewDeadlineConnection(conn, 30*time.Second, 30*time.Second)
+	}
+
+	firstTunnel := acceptTunnel()
+	defer firstTunnel.Close()
+	secondTunnel := acceptTunnel()
+	defer secondTunnel.Close()
+
+	_, err = firstTunnel.Write(payload)
+	require.Nil(t, err, "Failed to send payload over tunnel")
+
+	receivedPayload := make([]byte, len(payload))
+	_, err = io.ReadFull(firstTunnel, receivedPayload)
+	require.Nil(t, err, "Failed to receive payload over tunnel")
+	assert.Equal(t, payload, receivedPayload, "Different sent compared to received payload")
+
+	// NOTE: The used tunnel is replaced by a new idle one.
+	replacementTunnel := acceptTunnel()
+	defer replacementTunnel.Close()
+}
+
+func TestGocatConnectConnectTunnelHandshake(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	banner := []byte("220 gocat.test ESMTP\r\n")
+
+	// NOTE: The source speaks first, like SMTP.
+	srcSocket := tempUnixSocketPath(t, "gocat-tunnel-handshake-src")
+	srcListener, err := net.Listen("unix", srcSocket)
+	require.Nil(t, err, "Failed to listen with Unix socket src server")
+	defer srcListener.Close()
+
+	go func() {
+		for {
+			conn, err := srcListener.Accept()
+			if err != nil {
+				return
+			}
+
+			_, _ = conn.Write(banner)
+			_ = conn.Close()
+		}
+	}()
+
+	rendezvousListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to listen with rendezvous server")
+	defer rendezvousListener.Close()
+
+	runGocat(
+		ctx,
+		"connect-connect",
+		"--src",
+		srcSocket,
+		"--dst",
+		rendezvousListener.Addr().String(),
+		"--tunnel-handshake",
+		"GO",
+	)
+
+	err = rendezvousListener.(*net.TCPListener).SetDeadline(time.Now().Add(30 * time.Second))
+	require.Nil(t, err)
+
+	conn, err := rendezvousListener.Accept()
+	require.Nil(t, err, "Failed to accept tunnel from gocat")
+	tunnel := relay.NewDeadlineConnection(conn, 30*time.Second, 30*time.Second)
+	defer tunnel.Close()
+
+	_, err = tunnel.Write([]byte("GO"))
+	require.Nil(t, err, "Failed to send tunnel handshake")
+
+	receivedBanner := make([]byte, len(banner))
+	_, err = io.ReadFull(tunnel, receivedBanner)
+	require.Nil(t, err, "Failed to receive the source banner over tunnel")
+	assert.Equal(t, banner, receivedBanner)
+}
+
+func TestGocatMultiplexedTunnel(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	tunnelAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		tunnelAddress,
+		"--mux",
+	)
+
+	// NOTE: Wait for the listening side, `tcp-to-unix` stops when its initial health check fails.
+	tunnelClient := waitForTCPClient(ctx, t, tunnelAddress)
+	tunnelClient.Close()
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-mux-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		tunnelAddress,
+		"--dst",
+		dstListenAddress,
+		"--mux",
+	)
+
+	// NOTE: Several concurrent connections share the one tunnel.
+	clients := make([]*gocatTesting.UnixSocketClient, 3)
+	for i := range clients {
+		clients[i] = waitForUnixClient(ctx, t, dstListenAddress)
+		defer clients[i].Close()
+	}
+
+	for _, client := range clients {
+		assertEcho(t, client, payload)
+	}
+}
+
+func TestGocatUnixToTCPDeniedClient(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	dstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--deny-cidr", "127.0.0.0/8")
+	defer dstClient.Close()
+
+	// NOTE: Closed either gracefully (EOF) or by reset, since the sent payload is never read.
+	// Sending fails instead when the connection was already reset.
+	_, err := dstClient.SendMsg(payload)
+	if err == nil {
+		_, err = dstClient.ReceiveMsg(len(payload))
+	}
+	assert.NotNil(t, err, "Expected denied client connection to be closed")
+}
+
+func TestGocatUnixToTCPAllowedClient(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	dstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--allow-cidr", "10.0.0.0/8,127.0.0.1")
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+}
+
+func TestGocatUnixToTCPNotAllowedClient(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	dstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--allow-cidr", "10.0.0.0/8")
+	defer dstClient.Close()
+
+	_, err := dstClient.SendMsg(payload)
+	if err == nil {
+		_, err = dstClient.ReceiveMsg(len(payload))
+	}
+	assert.NotNil(t, err, "Expected not allowed client connection to be closed")
+}
+
+func TestGocatUnixToTCPIPRulesReload(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	tmpDir, err := ioutil.TempDir("", "gocat-ip-rules-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	rulesFile := filepath.Join(tmpDir, "ip-rules")
+	err = ioutil.WriteFile(rulesFile, []byte("deny 127.0.0.0/8\n"), 0600)
+	require.Nil(t, err, "Failed to write IP rules file")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	cmd, stderr, _ := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--ip-rules-file",
+		rulesFile,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	_, err = dstClient.SendMsg(payload)
+	if err == nil {
+		_, err = dstClient.ReceiveMsg(len(payload))
+	}
+	assert.NotNil(t, err, "Expected denied client connection to be closed")
+	dstClient.Close()
+
+	err = ioutil.WriteFile(rulesFile, []byte("allow 127.0.0.1\n"), 0600)
+	require.Nil(t, err, "Failed to rewrite IP rules file")
+
+	err = cmd.Process.Signal(syscall.SIGHUP)
+	require.Nil(t, err, "Failed to send SIGHUP to gocat")
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(stderr.String(), "Reloaded IP rules from "+rulesFile)
+	}, 10*time.Second, 50*time.Millisecond, "Expected IP rules to be reloaded")
+
+	dstClient = waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+}
+
+func TestGocatUnixToTCPIPRulesReloadWithoutFile(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	tmpDir, err := ioutil.TempDir("", "gocat-ip-rules-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	// NOTE: Reloaded right after the IP rules, telling when SIGHUP was handled.
+	bandwidthFile := filepath.Join(tmpDir, "bandwidth")
+	err = ioutil.WriteFile(bandwidthFile, []byte("upload 1M\n"), 0600)
+	require.Nil(t, err, "Failed to write bandwidth file")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	cmd, stderr, _ := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--allow-cidr",
+		"127.0.0.1",
+		"--bandwidth-file",
+		bandwidthFile,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	err = cmd.Process.Signal(syscall.SIGHUP)
+	require.Nil(t, err, "Failed to send SIGHUP to gocat")
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(stderr.String(), "Reloaded bandwidth limits from "+bandwidthFile)
+	}, 10*time.Second, 50*time.Millisecond, "Expected bandwidth limits to be reloaded")
+
+	assertEcho(t, dstClient, payload)
+	assert.NotContains(t, stderr.String(), "Reloaded IP rules")
+	assert.NotContains(t, stderr.String(), "Could not reload IP rules")
+}
+
+func TestGocatUnixToTCPConnectionLimits(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--max-connections-per-client",
+		"1",
+		"--connection-queue-timeout",
+		"10s",
+	)
+
+	firstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, firstClient, payload)
+
+	// NOTE: Queued until the first client disconnects.
+	secondClient, err := gocatTesting.NewTCPClient(dstListenAddress)
+	require.Nil(t, err, "Failed to connect to gocat dst address")
+	defer secondClient.Close()
+
+	_, err = secondClient.SendMsg(payload)
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+
+	received := make(chan error, 1)
+	go func() {
+		receivedPayload, err := secondClient.ReceiveMsg(len(payload))
+		if err == nil && !bytes.Equal(payload, receivedPayload) {
+			err = fmt.Errorf("received %q instead of %q", receivedPayload, payload)
+		}
+
+		received <- err
+	}()
+
+	select {
+	case err = <-received:
+		t.Fatalf("Expected second connection to be queued, got: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	firstClient.Close()
+	require.Nil(t, <-received, "Failed to receive payload of queued connection")
+}
+
+func TestGocatUnixToTCPClientRateLimit(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--client-rate-limit",
+		"0.01",
+		"--client-rate-limit-burst",
+		"1",
+	)
+
+	firstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer firstClient.Close()
+
+	assertEcho(t, firstClient, payload)
+
+	secondClient, err := gocatTesting.NewTCPClient(dstListenAddress)
+	require.Nil(t, err, "Failed to connect to gocat dst address")
+	defer secondClient.Close()
+
+	// NOTE: Closed either gracefully (EOF) or by reset, since the sent payload is never read.
+	// Sending fails instead when the connection was already reset.
+	_, err = secondClient.SendMsg(payload)
+	if err == nil {
+		_, err = secondClient.ReceiveMsg(len(payload))
+	}
+	assert.NotNil(t, err, "Expected rate limited client connection to be closed")
+}
+
+func TestGocatUnixToTCPGlobalRateLimitKeepsClientTokens(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--rate-limit",
+		"1",
+		"--rate-limit-burst",
+		"1",
+		"--client-rate-limit",
+		"0.01",
+		"--client-rate-limit-burst",
+		"1",
+	)
+
+	firstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer firstClient.Close()
+
+	// NOTE: Another client IP, rejected by the global limit which the first client used up.
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	rejectedConn, err := dialer.Dial("tcp", dstListenAddress)
+	require.Nil(t, err, "Failed to connect to gocat dst address")
+	defer rejectedConn.Close()
+
+	rejectedClient := relay.NewDeadlineConnection(rejectedConn, 10*time.Second, 10*time.Second)
+	_, err = rejectedClient.Write(payload)
+	if err == nil {
+		_, err = io.ReadFull(rejectedClient, make([]byte, len(payload)))
+	}
+	require.NotNil(t, err, "Expected globally rate limited client connection to be closed")
+
+	assertEcho(t, firstClient, payload)
+
+	// NOTE: Once the global bucket refilled, the second client still has its own token.
+	time.Sleep(1100 * time.Millisecond)
+
+	conn, err := dialer.Dial("tcp", dstListenAddress)
+	require.Nil(t, err, "Failed to connect to gocat dst address")
+	defer conn.Close()
+
+	secondClient := relay.NewDeadlineConnection(conn, 10*time.Second, 10*time.Second)
+	_, err = secondClient.Write(payload)
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+
+	received := make([]byte, len(payload))
+	_, err = io.ReadFull(secondClient, received)
+	require.Nil(t, err, "Expected client to be accepted once the global rate allows it")
+	assert.Equal(t, payload, received)
+}
+
+func TestGocatUnixToTCPBandwidthLimit(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte(testutils.RandString(192 * 1024))
+	dstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--conn-download-limit", "64K")
+	defer dstClient.Close()
+
+	// NOTE: The first 64K are the initial burst, the rest takes 2 seconds at 64K per second.
+	start := time.Now()
+	assertEcho(t, dstClient, payload)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(1900*time.Millisecond))
+}
+
+func TestGocatUnixToTCPFirstByteTimeout(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("SSH-2.0-test\r\n")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--first-byte-timeout",
+		"500ms",
+		"--handshake-delimiter",
+		"\\r\\n",
+	)
+
+	idleClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer idleClient.Close()
+
+	activeClient, err := gocatTesting.NewTCPClient(dstListenAddress)
+	require.Nil(t, err, "Failed to connect to gocat dst address")
+	defer activeClient.Close()
+
+	// NOTE: The handshake arrives in two parts, the source is dialed only after the delimiter.
+	_, err = activeClient.SendMsg(payload[:4])
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+	_, err = activeClient.SendMsg(payload[4:])
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+
+	receivedPayload, err := activeClient.ReceiveMsg(len(payload))
+	require.Nil(t, err, "Failed to receive payload from gocat dst address")
+	assert.Equal(t, payload, receivedPayload, "Different sent compared to received payload")
+
+	start := time.Now()
+	_, err = idleClient.ReceiveMsg(1)
+	assert.NotNil(t, err, "Expected idle client connection to be closed")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestGocatUnixToTCPConnectionLifecycleLogs(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("hello gocat lifecycle")
+	output, srcAddress := runGocatUnixToTCPSession(ctx, t, payload, "--name", "lifecycle")
+
+	assert.Contains(t, output, `msg="Opened connection" relay=lifecycle conn=1 client=127.0.0.1:`)
+	assert.Contains(t, output, `msg="Closed connection" relay=lifecycle conn=1 client=127.0.0.1:`)
+	assert.Contains(t, output, "src="+srcAddress)
+	assert.Contains(t, output, fmt.Sprintf("bytes_in=%d bytes_out=%d", len(payload), len(payload)))
+	assert.Contains(t, output, "reason=client_closed")
+}
+
+func TestGocatUnixToTCPJSONLogs(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("hello gocat json")
+	output, srcAddress := runGocatUnixToTCPSession(
+		ctx,
+		t,
+		payload,
+		"--log-format",
+		"json",
+		"--log-level",
+		"warn",
+		"--relay-log-level",
+		"debug",
+	)
+
+	var closeEntry map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		require.Nil(t, err, "Expected a JSON log line, got %s", line)
+
+		if entry["msg"] == "Closed connection" {
+			closeEntry = entry
+		}
+	}
+
+	require.NotNil(t, closeEntry, "Expected a close event in %s", output)
+	assert.Equal(t, "info", closeEntry["level"])
+	assert.Equal(t, "unix-to-tcp", closeEntry["relay"])
+	assert.Equal(t, float64(1), closeEntry["conn"])
+	assert.Equal(t, srcAddress, closeEntry["src"])
+	assert.Equal(t, float64(len(payload)), closeEntry["bytes_in"])
+	assert.Equal(t, float64(len(payload)), closeEntry["bytes_out"])
+	assert.Equal(t, "client_closed", closeEntry["reason"])
+}
+
+func TestGocatUnixToTCPTrafficDump(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	dumpFile, err := ioutil.TempFile("", "gocat-dump-test")
+	require.Nil(t, err, "Failed to create temporary dump file")
+	_ = dumpFile.Close()
+	defer stdOs.RemoveAll(dumpFile.Name())
+
+	payload := []byte("hello gocat dump")
+	dstClient := prepareGocatUnixToTCPTest(
+		ctx,
+		t,
+		len(payload),
+		"--dump",
+		"--dump-file",
+		dumpFile.Name(),
+		"--dump-max-chunk-bytes",
+		"5",
+	)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+
+	// NOTE: Echoed data is dumped once received back, right before it's written to the client.
+	dump, err := ioutil.ReadFile(dumpFile.Name())
+	require.Nil(t, err, "Failed to read dump file")
+	assert.Regexp(t, `(?m)^> \S+ \S+ conn=\d+ client=127\.0\.0\.1:\d+ length=16 from=0 to=15 truncated=11$`, string(dump))
+	assert.Regexp(t, `(?m)^< \S+ \S+ conn=\d+ client=127\.0\.0\.1:\d+ length=16 from=0 to=15 truncated=11$`, string(dump))
+	assert.Contains(t, string(dump), "68 65 6c 6c 6f")
+	assert.Contains(t, string(dump), "|hello|")
+}
+
+func TestGocatUnixToTCPCapture(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	tmpDir, err := ioutil.TempDir("", "gocat-capture-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	payload := []byte("hello gocat capture")
+	dstClient := prepareGocatUnixToTCPTest(
+		ctx,
+		t,
+		len(payload),
+		"--capture",
+		filepath.Join(tmpDir, "gocat"),
+		"--capture-per-conn",
+	)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+
+	captureFiles, err := filepath.Glob(filepath.Join(tmpDir, "gocat-conn*-*.pcapng"))
+	require.Nil(t, err, "Failed to list capture files")
+	require.NotEmpty(t, captureFiles, "Expected a capture file")
+
+	var capture []byte
+	for _, captureFile := range captureFiles {
+		data, err := ioutil.ReadFile(captureFile)
+		require.Nil(t, err, "Failed to read capture file")
+
+		capture = append(capture, data...)
+	}
+
+	// NOTE: pcapng section header block type.
+	assert.Equal(t, []byte{0x0A, 0x0D, 0x0D, 0x0A}, capture[:4])
+	// NOTE: Both legs carry the payload in both directions.
+	assert.Equal(t, 4, bytes.Count(capture, payload))
+}
+
+func TestGocatUnixToTCPSyslogSink(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	syslogConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to listen with syslog stand-in")
+	defer syslogConn.Close()
+
+	messagesCh := receiveDatagrams(syslogConn)
+
+	payload := []byte("hello gocat syslog")
+	_, _ = runGocatUnixToTCPSession(
+		ctx,
+		t,
+		payload,
+		"--log-sink",
+		"syslog",
+		"--syslog-address",
+		"udp://"+syslogConn.LocalAddr().String(),
+		"--syslog-facility",
+		"local0",
+	)
+
+	var openMessage string
+	for message := range messagesCh {
+		if strings.HasSuffix(message, " Opened connection") {
+			openMessage = message
+			break
+		}
+	}
+
+	// NOTE: local0 (16) * 8 + info (6)
+	assert.True(t, strings.HasPrefix(openMessage, "<134>1 "), "Unexpected syslog message %s", openMessage)
+	assert.Contains(t, openMessage, ` gocat `)
+	assert.Contains(t, openMessage, `[gocat@32473 relay="unix-to-tcp" conn="1" client="127.0.0.1:`)
+}
+
+func TestGocatUnixToTCPSyslogSinkNotReading(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	// NOTE: A syslog stand-in never reading, so its receive queue fills up after a few entries.
+	syslogPath := tempUnixSocketPath(t, "gocat-syslog")
+	syslogConn, err := net.ListenPacket("unixgram", syslogPath)
+	require.Nil(t, err, "Failed to listen with syslog stand-in")
+	defer syslogConn.Close()
+
+	srcAddress := tempUnixSocketPath(t, "gocat-src")
+	srcListener, _ := serveUnixEchoSource(t, srcAddress)
+	defer srcListener.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"--log-sink",
+		"syslog",
+		"--syslog-address",
+		"unix://"+syslogPath,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		dstListenAddress,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	dstClient.Close()
+
+	payload := []byte("hello gocat syslog")
+	buffer := make([]byte, len(payload))
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", dstListenAddress)
+		require.Nil(t, err, "Failed to dial gocat dst address")
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		require.Nil(t, err, "Failed to set deadline")
+
+		_, err = conn.Write(payload)
+		require.Nil(t, err, "Failed to send payload to gocat dst address")
+
+		_, err = io.ReadFull(conn, buffer)
+		require.Nil(t, err, "Expected connection %d to be relayed while syslog doesn't read", i+1)
+
+		_ = conn.Close()
+	}
+}
+
+func TestGocatUnixToTCPJournaldSink(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	journaldSocket := tempUnixSocketPath(t, "gocat-journald")
+	journaldConn, err := net.ListenPacket("unixgram", journaldSocket)
+	require.Nil(t, err, "Failed to listen with journald stand-in")
+	defer journaldConn.Close()
+	defer stdOs.Remove(journaldSocket)
+
+	messagesCh := receiveDatagrams(journaldConn)
+
+	payload := []byte("hello gocat journald")
+	_, _ = runGocatUnixToTCPSession(
+		ctx,
+		t,
+		payload,
+		"--log-sink",
+		"journald",
+		"--journald-socket",
+		journaldSocket,
+	)
+
+	var closeMessage string
+	for message := range messagesCh {
+		if strings.HasPrefix(message, "MESSAGE=Closed connection\n") {
+			closeMessage = message
+			break
+		}
+	}
+
+	assert.Contains(t, closeMessage, "PRIORITY=6\n")
+	assert.Contains(t, closeMessage, "SYSLOG_IDENTIFIER=gocat\n")
+	assert.Contains(t, closeMessage, "RELAY=unix-to-tcp\n")
+	assert.Contains(t, closeMessage, "CONN=1\n")
+	assert.Contains(t, closeMessage, fmt.Sprintf("BYTES_IN=%d\n", len(payload)))
+	assert.Contains(t, closeMessage, "REASON=client_closed\n")
+}
+
+func TestGocatUnixToTCPTracing(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	requestsCh := make(chan []byte, 16)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil && r.URL.Path == "/v1/traces" && r.Header.Get("Authorization") == "Bearer token" {
+			requestsCh <- body
+		}
+	}))
+	defer collector.Close()
+
+	payload := []byte("hello gocat tracing")
+	_, srcAddress := runGocatUnixToTCPSession(
+		ctx,
+		t,
+		payload,
+		"--otlp-endpoint",
+		collector.URL,
+		"--otlp-header",
+		"Authorization=Bearer token",
+		"--otlp-flush-interval",
+		"100ms",
+	)
+
+	var body []byte
+	select {
+	case body = <-requestsCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Expected spans to be exported to the collector")
+	}
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					Name       string `json:"name"`
+					Attributes []struct {
+						Key   string                 `json:"key"`
+						Value map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+					Events []struct {
+						Name string `json:"name"`
+					} `json:"events"`
+					Status struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err := json.Unmarshal(body, &request)
+	require.Nil(t, err, "Failed to decode exported spans %s", body)
+	require.Len(t, request.ResourceSpans, 1)
+	require.Len(t, request.ResourceSpans[0].ScopeSpans, 1)
+	require.Len(t, request.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+
+	span := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "gocat.connection", span.Name)
+	assert.Len(t, span.TraceID, 32)
+	assert.Equal(t, 1, span.Status.Code)
+
+	attributes := map[string]interface{}{}
+	for _, attribute := range span.Attributes {
+		for _, value := range attribute.Value {
+			attributes[attribute.Key] = value
+		}
+	}
+
+	assert.Equal(t, "unix-to-tcp", attributes["gocat.relay"])
+	assert.Equal(t, "1", attributes["gocat.connection.id"])
+	assert.Equal(t, srcAddress, attributes["gocat.source.address"])
+	assert.Equal(t, strconv.Itoa(len(payload)), attributes["gocat.bytes_in"])
+	assert.Equal(t, strconv.Itoa(len(payload)), attributes["gocat.bytes_out"])
+	assert.Equal(t, "client_closed", attributes["gocat.close_reason"])
+
+	var events []string
+	for _, event := range span.Events {
+		events = append(events, event.Name)
+	}
+
+	assert.Equal(t, []string{"accepted", "source_dial_started", "source_dial_finished", "first_byte", "closed"}, events)
+}
+
+func TestGocatUnixToTCPStatsD(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	statsDConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to listen with StatsD stand-in")
+	defer statsDConn.Close()
+
+	datagramsCh := receiveDatagrams(statsDConn)
+
+	payload := []byte("hello gocat statsd")
+	_, _ = runGocatUnixToTCPSession(
+		ctx,
+		t,
+		payload,
+		"--statsd-address",
+		"udp://"+statsDConn.LocalAddr().String(),
+		"--statsd-flush-interval",
+		"100ms",
+	)
+
+	expected := []string{
+		"gocat.health_checks_total:1|c|#relay:unix-to-tcp,result:success",
+		"gocat.connections_accepted_total:1|c|#relay:unix-to-tcp",
+		"gocat.connections_closed_total:1|c|#relay:unix-to-tcp,reason:client_closed",
+		fmt.Sprintf("gocat.bytes_total:%d|c|#relay:unix-to-tcp,direction:in", len(payload)),
+		fmt.Sprintf("gocat.bytes_total:%d|c|#relay:unix-to-tcp,direction:out", len(payload)),
+	}
+
+	var lines []string
+	for datagram := range datagramsCh {
+		lines = append(lines, strings.Split(datagram, "\n")...)
+		if strings.Contains(datagram, "gocat.connection_duration:") {
+			break
+		}
+	}
+
+	for _, line := range expected {
+		assert.Contains(t, lines, line)
+	}
+}
+
+func TestGocatUnixToTCPStatsDAgentStartingLater(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	statsDPath := tempUnixSocketPath(t, "gocat-statsd")
+	defer stdOs.Remove(statsDPath)
+
+	srcAddress := tempUnixSocketPath(t, "gocat-src")
+	srcListener, _ := serveUnixEchoSource(t, srcAddress)
+	defer srcListener.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	// NOTE: The agent's socket doesn't exist yet.
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		dstListenAddress,
+		"--statsd-address",
+		"unixgram://"+statsDPath,
+		"--statsd-flush-interval",
+		"100ms",
+	)
+
+	payload := []byte("hello gocat statsd")
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	statsDConn, err := net.ListenPacket("unixgram", statsDPath)
+	require.Nil(t, err, "Failed to listen with StatsD stand-in")
+	defer statsDConn.Close()
+
+	datagramsCh := receiveDatagrams(statsDConn)
+
+	dstClient = waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	found := false
+	for datagram := range datagramsCh {
+		if strings.Contains(datagram, "gocat.connections_accepted_total:1|c|#relay:unix-to-tcp") {
+			found = true
+			break
+		}
+	}
+	assert.True(t, found, "Expected metrics once the StatsD agent listens")
+}
+
+func TestGocatUnixToTCPAdminCtl(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("hello gocat ctl")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	adminSocket := tempUnixSocketPath(t, "gocat-admin")
+	defer stdOs.Remove(adminSocket)
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--name",
+		"ctl-test",
+		"--admin-socket",
+		adminSocket,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+	assertEcho(t, dstClient, payload)
+
+	ctl := func(args ...string) (string, error) {
+		binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+		stdout, stderr, err := binaryBuild.Run(ctx, append([]string{"ctl", "--admin", adminSocket}, args...)...)
+
+		return stdout + stderr, err
+	}
+
+	output, err := ctl("relays")
+	require.Nil(t, err, "Failed to list relays: %s", output)
+	assert.Regexp(t, `ctl-test\s+unix socket\s+TCP connection `+regexp.QuoteMeta(dstListenAddress)+`\s+false\s+1\s+1`, output)
+
+	output, err = ctl("connections")
+	require.Nil(t, err, "Failed to list connections: %s", output)
+	assert.Regexp(
+		t,
+		`(?m)^1\s+127\.0\.0\.1:\d+\s+`+regexp.QuoteMeta(testSrcServerListenResult.Address)+`\s+\S+\s+`+
+			strconv.Itoa(len(payload))+`\s+`+strconv.Itoa(len(payload))+`$`,
+		output,
+	)
+
+	output, err = ctl("kill", "1")
+	require.Nil(t, err, "Failed to kill connection: %s", output)
+	assert.Contains(t, output, "Killed connection 1")
+
+	_, err = dstClient.ReceiveMsg(1)
+	assert.NotNil(t, err, "Expected the killed connection to be closed")
+
+	output, err = ctl("kill", "1")
+	assert.NotNil(t, err, "Expected killing a closed connection to fail")
+	assert.Contains(t, output, "no connection with ID 1")
+
+	output, err = ctl("pause")
+	require.Nil(t, err, "Failed to pause relay: %s", output)
+
+	output, err = ctl("relays")
+	require.Nil(t, err, "Failed to list relays: %s", output)
+	assert.Regexp(t, `ctl-test\s+.+\s+true\s+0\s+1`, output)
+
+	output, err = ctl("resume")
+	require.Nil(t, err, "Failed to resume relay: %s", output)
+
+	resumedClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer resumedClient.Close()
+	assertEcho(t, resumedClient, payload)
+
+	output, err = ctl("health-check")
+	require.Nil(t, err, "Failed to health check: %s", output)
+	assert.Contains(t, output, "ctl-test is healthy")
+}
+
+func TestGocatUnixToTCPAdminCtlFailingSource(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("hello gocat ctl")
+
+	srcAddress := tempUnixSocketPath(t, "gocat-src")
+	srcListener, _ := serveUnixEchoSource(t, srcAddress)
+	defer srcListener.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	adminSocket := tempUnixSocketPath(t, "gocat-admin")
+	defer stdOs.Remove(adminSocket)
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		dstListenAddress,
+		"--name",
+		"ctl-test",
+		"--health-check-interval",
+		"1h",
+		"--admin-socket",
+		adminSocket,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	adminSocketInfo, err := stdOs.Stat(adminSocket)
+	require.Nil(t, err, "Failed to stat admin unix socket")
+	assert.Equal(t, stdOs.FileMode(0600), adminSocketInfo.Mode().Perm())
+
+	ctl := func(args ...string) (string, error) {
+		binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+		stdout, stderr, err := binaryBuild.Run(
+			ctx,
+			append([]string{"ctl", "--admin", adminSocket, "--timeout", "5s"}, args...)...,
+		)
+
+		return stdout + stderr, err
+	}
+
+	err = srcListener.Close()
+	require.Nil(t, err, "Failed to close Unix socket src server")
+
+	output, err := ctl("health-check")
+	assert.NotNil(t, err, "Expected the health check of a closed source to fail")
+	assert.Contains(t, output, "ctl-test is unhealthy")
+
+	output, err = ctl("relays")
+	require.Nil(t, err, "Expected the relay to keep running after a failed health check: %s", output)
+	assert.Contains(t, output, "ctl-test")
+}
+
+func TestGocatUnixToTCPAdminListenToken(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("hello gocat ctl")
+
+	srcAddress := tempUnixSocketPath(t, "gocat-src")
+	srcListener, _ := serveUnixEchoSource(t, srcAddress)
+	defer srcListener.Close()
+
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err, "Failed to create temporary address")
+		addresses = append(addresses, l.Addr().String())
+		err = l.Close()
+		require.Nil(t, err, "Failed to close temporary TCP listener")
+	}
+	dstListenAddress, adminListenAddress := addresses[0], addresses[1]
+
+	tmpDir, err := ioutil.TempDir("", "gocat-admin-token-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	tokenFile := filepath.Join(tmpDir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600)
+	require.Nil(t, err, "Failed to write admin token file")
+
+	_, stderr, exited := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		dstListenAddress,
+		"--admin-listen",
+		"0.0.0.0:0",
+	)
+
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "Expected gocat to refuse a non-loopback admin address without token")
+	}
+	assert.Contains(t, stderr.String(), "refusing to serve the admin API at 0.0.0.0:0")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		dstListenAddress,
+		"--name",
+		"ctl-test",
+		"--admin-listen",
+		adminListenAddress,
+		"--admin-token-file",
+		tokenFile,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+	assertEcho(t, dstClient, payload)
+
+	ctl := func(args ...string) (string, error) {
+		binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+		stdout, stderr, err := binaryBuild.Run(
+			ctx,
+			append([]string{"ctl", "--admin", "http://" + adminListenAddress}, args...)...,
+		)
+
+		return stdout + stderr, err
+	}
+
+	output, err := ctl("relays")
+	assert.NotNil(t, err, "Expected listing relays without token to fail")
+	assert.Contains(t, output, "401 Unauthorized")
+
+	output, err = ctl("--token-file", tokenFile, "relays")
+	require.Nil(t, err, "Failed to list relays with token: %s", output)
+	assert.Contains(t, output, "ctl-test")
+}
+
+func TestGocatUnixToTCPClosesAdminSocketOnFailedStart(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	srcAddress := tempUnixSocketPath(t, "gocat-src")
+	srcListener, _ := serveUnixEchoSource(t, srcAddress)
+	defer srcListener.Close()
+
+	adminSocket := tempUnixSocketPath(t, "gocat-admin")
+	defer stdOs.Remove(adminSocket)
+
+	_, stderr, exited := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		srcAddress,
+		"--dst",
+		"127.0.0.1:0",
+		"--admin-socket",
+		adminSocket,
+		"--metrics-listen",
+		"invalid-address",
+	)
+
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "Expected gocat to fail listening for metrics")
+	}
+	assert.Contains(t, stderr.String(), "failed to listen for metrics")
+
+	_, err := stdOs.Lstat(adminSocket)
+	assert.True(t, stdOs.IsNotExist(err), "Expected the admin unix socket to be removed, got %v", err)
+}
+
+func TestGocatUnixToTCPKillWhileShaping(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte(testutils.RandString(32 * 1024))
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	adminSocket := tempUnixSocketPath(t, "gocat-admin")
+	defer stdOs.Remove(adminSocket)
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--name",
+		"shaping-test",
+		"--admin-socket",
+		adminSocket,
+		"--conn-upload-limit",
+		"1K",
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	// NOTE: Takes half a minute to upload at 1K per second.
+	_, err = dstClient.SendMsg(payload)
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+
+	ctl := func(args ...string) (string, error) {
+		binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+		stdout, stderr, err := binaryBuild.Run(ctx, append([]string{"ctl", "--admin", adminSocket}, args...)...)
+
+		return stdout + stderr, err
+	}
+
+	output, err := ctl("kill", "1")
+	require.Nil(t, err, "Failed to kill connection: %s", output)
+
+	assert.Eventually(t, func() bool {
+		output, err := ctl("relays")
+		return err == nil && regexp.MustCompile(`shaping-test\s+.+\s+false\s+0\s+1`).MatchString(output)
+	}, 3*time.Second, 100*time.Millisecond, "Expected the killed connection to stop waiting for its upload limit")
+}
+
+func TestGocatUnixToTCPAccessLog(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	tmpDir, err := ioutil.TempDir("", "gocat-access-log-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	payload := []byte("hello gocat access log")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	accessLogPath := filepath.Join(tmpDir, "access.log")
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--access-log",
+		accessLogPath,
+		"--access-log-template",
+		"{{.Connection}} {{.BytesIn}} {{.BytesOut}} {{.Reason}}",
+		// NOTE: Rotate on every line but the first one of a file.
+		"--access-log-max-size",
+		"1",
+		"--access-log-compress",
+	)
+
+	for i := 0; i < 2; i++ {
+		dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+		assertEcho(t, dstClient, payload)
+		dstClient.Close()
+
+		// NOTE: Wait for the line of the closed connection, one at a time to know which file it ends up in.
+		connectionID := []byte(fmt.Sprintf("%d ", i+1))
+		require.Eventually(t, func() bool {
+			lines, err := ioutil.ReadFile(accessLogPath)
+			return err == nil && bytes.HasPrefix(lines, connectionID)
+		}, 5*time.Second, 100*time.Millisecond, "Expected an access log line of conn=%d", i+1)
+	}
+
+	var rotated []string
+	require.Eventually(t, func() bool {
+		rotated, err = filepath.Glob(accessLogPath + ".*.gz")
+		return err == nil && len(rotated) > 0
+	}, 5*time.Second, 100*time.Millisecond, "Expected a compressed rotated access log")
+	require.Len(t, rotated, 1)
+
+	rotatedFile, err := stdOs.Open(rotated[0])
+	require.Nil(t, err, "Failed to open rotated access log")
+	defer rotatedFile.Close()
+
+	gzipReader, err := gzip.NewReader(rotatedFile)
+	require.Nil(t, err, "Failed to read compressed rotated access log")
+
+	rotatedLines, err := ioutil.ReadAll(gzipReader)
+	require.Nil(t, err, "Failed to decompress rotated access log")
+
+	expectedLine := fmt.Sprintf("%d %d %d client_closed\n", 1, len(payload), len(payload))
+	assert.Equal(t, expectedLine, string(rotatedLines))
+
+	currentLines, err := ioutil.ReadFile(accessLogPath)
+	require.Nil(t, err, "Failed to read access log")
+
+	expectedLine = fmt.Sprintf("%d %d %d client_closed\n", 2, len(payload), len(payload))
+	assert.Equal(t, expectedLine, string(currentLines))
+}
+
+func TestGocatUnixToTCPAccessLogRotationFailure(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	tmpDir, err := ioutil.TempDir("", "gocat-access-log-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	payload := []byte("hello gocat access log")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	accessLogPath := filepath.Join(tmpDir, "access.log")
+	_, stderr, _ := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--access-log",
+		accessLogPath,
+		"--access-log-template",
+		"{{.Connection}} {{.Reason}}",
+		"--access-log-max-size",
+		"1",
+	)
+
+	for i := 0; i < 2; i++ {
+		dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+		assertEcho(t, dstClient, payload)
+		dstClient.Close()
+
+		connectionID := []byte(fmt.Sprintf("%d ", i+1))
+		require.Eventually(t, func() bool {
+			lines, err := ioutil.ReadFile(accessLogPath)
+			return err == nil && bytes.HasPrefix(lines, connectionID)
+		}, 5*time.Second, 100*time.Millisecond, "Expected an access log line of conn=%d", i+1)
+
+		// NOTE: Rotating the removed file fails, yet following lines must still be written.
+		err = stdOs.Remove(accessLogPath)
+		require.Nil(t, err, "Failed to remove access log")
+	}
+
+	assert.Contains(t, stderr.String(), "Could not rotate access log")
+}
+
+func TestGocatUnixToTCPAccessLogReopenFailure(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	tmpDir, err := ioutil.TempDir("", "gocat-access-log-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	payload := []byte("hello gocat access log")
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	accessLogPath := filepath.Join(tmpDir, "access.log")
+	movedAccessLogPath := accessLogPath + ".1"
+	cmd, stderr, _ := startGocat(
+		ctx,
+		t,
+		"unix-to-tcp",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--access-log",
+		accessLogPath,
+		"--access-log-template",
+		"{{.Connection}} {{.Reason}}",
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	require.Eventually(t, func() bool {
+		lines, err := ioutil.ReadFile(accessLogPath)
+		return err == nil && bytes.HasPrefix(lines, []byte("1 "))
+	}, 5*time.Second, 100*time.Millisecond, "Expected an access log line of conn=1")
+
+	// NOTE: Moved away like logrotate does, but a directory in its place fails reopening it.
+	err = stdOs.Rename(accessLogPath, movedAccessLogPath)
+	require.Nil(t, err, "Failed to move access log")
+	err = stdOs.Mkdir(accessLogPath, 0700)
+	require.Nil(t, err, "Failed to create directory at access log path")
+
+	err = cmd.Process.Signal(syscall.SIGUSR1)
+	require.Nil(t, err, "Failed to send SIGUSR1 to gocat")
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(stderr.String(), "Could not reopen access log")
+	}, 5*time.Second, 100*time.Millisecond, "Expected reopening the access log to fail")
+
+	dstClient = waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	require.Eventually(t, func() bool {
+		lines, err := ioutil.ReadFile(movedAccessLogPath)
+		return err == nil && bytes.Contains(lines, []byte("\n2 "))
+	}, 5*time.Second, 100*time.Millisecond, "Expected conn=2 to be logged to the previous access log")
+}
+
+func TestGocatUnixToTCPRecordReplay(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	tmpDir, err := ioutil.TempDir("", "gocat-record-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	recordingFile := filepath.Join(tmpDir, "sessions.jsonl")
+	payload := []byte("hello gocat replay")
+	dstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--record", recordingFile)
+
+	assertEcho(t, dstClient, payload)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	// NOTE: Another process appending its own conn=1 to the same recording.
+	otherDstClient := prepareGocatUnixToTCPTest(ctx, t, len(payload), "--record", recordingFile)
+	assertEcho(t, otherDstClient, payload)
+	otherDstClient.Close()
+
+	binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+
+	echoServer := gocatTesting.NewUnixServer(t, len(payload))
+	echoServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go echoServer.Serve(echoServerListenCh)
+	echoServerListenResult := <-echoServerListenCh
+	require.Nil(t, echoServerListenResult.Err, "Failed to listen with Unix socket echo server")
+
+	stdout, stderr, err := binaryBuild.Run(
+		ctx,
+		"replay",
+		"--file",
+		recordingFile,
+		"--target",
+		echoServerListenResult.Address,
+		"--target-network",
+		"unix",
+		"--speed",
+		"0",
+	)
+	assert.Nil(t, err, "Expected replay against an echo server to match, stdout: %s, stderr: %s", stdout, stderr)
+	assert.Contains(t, stdout+stderr, "Replayed 2 sessions without divergence")
+
+	// NOTE: Responds with the uppercased client data, diverging from the recorded echo.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to listen with diverging TCP server")
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				buffer := make([]byte, len(payload))
+				for {
+					n, err := conn.Read(buffer)
+					if err != nil {
+						return
+					}
+
+					_, _ = conn.Write(bytes.ToUpper(buffer[:n]))
+				}
+			}(conn)
+		}
+	}()
+
+	stdout, stderr, err = binaryBuild.Run(
+		ctx,
+		"replay",
+		"--file",
+		recordingFile,
+		"--target",
+		l.Addr().String(),
+		"--response-timeout",
+		"1s",
+	)
+	assert.NotNil(t, err, "Expected replay against a diverging server to fail")
+	assert.Contains(t, stdout+stderr, "responses diverge at byte 0")
+}
+
+func TestGocatTCPToUnixPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unix socket peer credentials are only supported on linux")
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+	uid := strconv.Itoa(stdOs.Getuid())
+	otherUID := strconv.Itoa(stdOs.Getuid() + 1)
+
+	allowedClient := prepareGocatTCPToUnixTest(ctx, t, len(payload), "--allow-peer-user", uid)
+	defer allowedClient.Close()
+
+	assertEcho(t, allowedClient, payload)
+
+	allowedGroupClient := prepareGocatTCPToUnixTest(
+		ctx,
+		t,
+		len(payload),
+		"--allow-peer-user",
+		otherUID,
+		"--allow-peer-group",
+		strconv.Itoa(stdOs.Getgid()),
+	)
+	defer allowedGroupClient.Close()
+
+	assertEcho(t, allowedGroupClient, payload)
+
+	// NOTE: The test process is the unix socket client.
+	executable, err := stdOs.Executable()
+	require.Nil(t, err, "Failed to get test executable")
+
+	allowedExecutableClient := prepareGocatTCPToUnixTest(
+		ctx,
+		t,
+		len(payload),
+		"--allow-peer-user",
+		otherUID,
+		"--allow-peer-exe",
+		executable,
+	)
+	defer allowedExecutableClient.Close()
+
+	assertEcho(t, allowedExecutableClient, payload)
+
+	groups, err := stdOs.Getgroups()
+	require.Nil(t, err, "Failed to get supplementary groups")
+	for _, group := range groups {
+		if group == stdOs.Getgid() {
+			continue
+		}
+
+		allowedSupplementaryGroupClient := prepareGocatTCPToUnixTest(
+			ctx,
+			t,
+			len(payload),
+			"--allow-peer-user",
+			otherUID,
+			"--allow-peer-group",
+			strconv.Itoa(group),
+		)
+		defer allowedSupplementaryGroupClient.Close()
+
+		assertEcho(t, allowedSupplementaryGroupClient, payload)
+
+		break
+	}
+
+	deniedClient := prepareGocatTCPToUnixTest(
+		ctx,
+		t,
+		len(payload),
+		"--allow-peer-user",
+		otherUID,
+		"--allow-peer-exe",
+		"/bin/false",
+	)
+	defer deniedClient.Close()
+
+	// NOTE: Either sending fails or receiving, depending on whether the connection was closed before sending.
+	_, err = deniedClient.SendMsg(payload)
+	if err == nil {
+		_, err = deniedClient.ReceiveMsg(len(payload))
+	}
+	assert.NotNil(t, err, "Expected denied client connection to be closed")
+}
+
+func TestGocatTCPToUnixKeepsReplacedSocket(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	testSrcServer := gocatTesting.NewTCPServer(t, 6, "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-replaced-socket")
+	cmd, _, exited := startGocat(
+		ctx,
+		t,
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--dst-mode",
+		"0600",
+	)
+
+	dstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	dstClient.Close()
+
+	// NOTE: E.g another instance taking over after this one was considered stale.
+	err := stdOs.Remove(dstListenAddress)
+	require.Nil(t, err, "Failed to remove gocat dst socket")
+
+	replacement, err := net.Listen("unix", dstListenAddress)
+	require.Nil(t, err, "Failed to listen at gocat dst address")
+	defer replacement.Close()
+
+	err = cmd.Process.Signal(syscall.SIGTERM)
+	require.Nil(t, err, "Failed to send SIGTERM to gocat")
+
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "Expected gocat to exit on SIGTERM")
+	}
+
+	_, err = stdOs.Lstat(dstListenAddress)
+	assert.Nil(t, err, "Expected the replacing socket to be kept")
+}
+
+func TestGocatTCPToUnixSocketPermissions(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	tmpDir, err := ioutil.TempDir("", "gocat-socket-permissions-test")
+	require.Nil(t, err, "Failed to create temporary directory")
+	defer stdOs.RemoveAll(tmpDir)
+
+	dstListenAddress := filepath.Join(tmpDir, "nested", "gocat.sock")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--dst-mode",
+		"0600",
+		"--dst-create-dir",
+		"--dst-dir-mode",
+		"0710",
+	)
+
+	dstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+
+	socketInfo, err := stdOs.Stat(dstListenAddress)
+	require.Nil(t, err, "Failed to stat gocat dst unix socket")
+	assert.Equal(t, stdOs.FileMode(0600), socketInfo.Mode().Perm())
+
+	dirInfo, err := stdOs.Stat(filepath.Dir(dstListenAddress))
+	require.Nil(t, err, "Failed to stat gocat dst unix socket directory")
+	assert.Equal(t, stdOs.FileMode(0710), dirInfo.Mode().Perm())
+}
+
+func TestGocatTCPToUnixStaleSocket(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	// NOTE: Leave a socket nobody accepts connections on, as a crashed instance would.
+	dstListenAddress := tempUnixSocketPath(t, "gocat-stale-socket-test")
+	staleListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: dstListenAddress, Net: "unix"})
+	require.Nil(t, err, "Failed to listen at stale unix socket")
+	staleListener.SetUnlinkOnClose(false)
+	_ = staleListener.Close()
+	defer stdOs.RemoveAll(dstListenAddress)
+
+	args := []string{
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--dst-lock-file",
+	}
+	runGocat(ctx, args...)
+	defer stdOs.RemoveAll(dstListenAddress + ".lock")
+
+	dstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+
+	// NOTE: A second instance must refuse to take over the socket in use.
+	secondCtx, cancelSecondCtx := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelSecondCtx()
+
+	_, _, err = testutils.NewBuild(gocatBinaryPath, "").Run(secondCtx, args...)
+	require.NotNil(t, err, "Second gocat instance expected to fail")
+	require.Nil(t, secondCtx.Err(), "Second gocat instance expected to exit")
+
+	assertEcho(t, dstClient, payload)
+
+	newDstClient := waitForUnixClient(ctx, t, dstListenAddress)
+	defer newDstClient.Close()
+
+	assertEcho(t, newDstClient, payload)
+}
+
+func TestGocatAbstractUnixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are only supported on linux")
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	payload := []byte("123456")
+
+	testSrcServer := gocatTesting.NewTCPServer(t, len(payload), "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	abstractAddress := "@gocat-abstract-test-" + strconv.Itoa(stdOs.Getpid())
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		abstractAddress,
+	)
+
+	// NOTE: Wait for the abstract socket, `unix-to-tcp` stops when its initial health check fails.
+	abstractClient := waitForUnixClient(ctx, t, abstractAddress)
+	abstractClient.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		abstractAddress,
+		"--dst",
+		dstListenAddress,
+	)
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	defer dstClient.Close()
+
+	assertEcho(t, dstClient, payload)
+}
+
+func TestGocatTCPToUnixSeqPacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("seqpacket unix sockets are tested only on linux")
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	testSrcServer := gocatTesting.NewTCPServer(t, 1024, "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-seqpacket-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--dst-seqpacket",
+		"--length-prefix",
+	)
+
+	var dstClient net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		dstClient, err = net.Dial("unixpacket", dstListenAddress)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nil(t, err, "Failed to dial gocat seqpacket dst address")
+	defer dstClient.Close()
+
+	messages := [][]byte{[]byte("abc"), []byte("de"), []byte("fghij")}
+	for _, message := range messages {
+		_, err = dstClient.Write(message)
+		require.Nil(t, err, "Failed to send packet to gocat dst address")
+	}
+
+	// NOTE: The TCP src echoes the framed messages back in arbitrary chunks,
+	// yet each one must come back as its own packet.
+	buffer := make([]byte, 1024)
+	for _, message := range messages {
+		err = dstClient.SetReadDeadline(time.Now().Add(10 * time.Second))
+		require.Nil(t, err, "Failed to set read deadline")
+
+		n, err := dstClient.Read(buffer)
+		require.Nil(t, err, "Failed to receive packet from gocat dst address")
+		assert.Equal(t, message, buffer[:n], "Different sent compared to received packet")
+	}
+}
+
+func TestGocatTCPToUnixSeqPacketTruncated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("seqpacket unix sockets are tested only on linux")
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	testSrcServer := gocatTesting.NewTCPServer(t, 1024, "127.0.0.1:0")
+	serverListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(serverListenCh)
+	testSrcServerListenResult := <-serverListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
+
+	dstListenAddress := tempUnixSocketPath(t, "gocat-seqpacket-truncated-test")
+	runGocat(
+		ctx,
+		"tcp-to-unix",
+		"--src",
+		testSrcServerListenResult.Address,
+		"--dst",
+		dstListenAddress,
+		"--dst-seqpacket",
+		"--buffer-size",
+		"4",
+	)
+
+	var dstClient net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		dstClient, err = net.Dial("unixpacket", dstListenAddress)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nil(t, err, "Failed to dial gocat seqpacket dst address")
+	defer dstClient.Close()
+
+	_, err = dstClient.Write([]byte("abcdefgh"))
+	require.Nil(t, err, "Failed to send packet to gocat dst address")
+
+	err = dstClient.SetReadDeadline(time.Now().Add(10 * time.Second))
+	require.Nil(t, err, "Failed to set read deadline")
+
+	// NOTE: Closed instead of relaying the first 4 bytes of the packet.
+	buffer := make([]byte, 1024)
+	n, err := dstClient.Read(buffer)
+	assert.Equal(t, io.EOF, err, "Expected connection with a truncated packet to be closed")
+	assert.Equal(t, 0, n)
+}
+
+func TestGocatUnixToTCPSeqPacketTruncatedFromSourcePool(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("seqpacket unix sockets are tested only on linux")
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	srcSocket := tempUnixSocketPath(t, "gocat-seqpacket-pool-src")
+	srcListener, err := net.Listen("unixpacket", srcSocket)
+	require.Nil(t, err, "Failed to listen with seqpacket src server")
+	defer srcListener.Close()
+
+	var accepted int64
+	go func() {
+		// NOTE: Kept open until the listener is, with a packet larger than the buffer waiting in them.
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := srcListener.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt64(&accepted, 1)
+			conns = append(conns, conn)
+			_, _ = conn.Write([]byte("abcdefgh"))
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	runGocat(
+		ctx,
+		"unix-to-tcp",
+		"--src",
+		srcSocket,
+		"--dst",
+		dstListenAddress,
+		"--src-seqpacket",
+		"--buffer-size",
+		"4",
+		"--health-check-interval",
+		"1h",
+		"--src-pool-size",
+		"1",
+	)
+
+	// NOTE: The initial health check and the pre-dialed connection.
+	require.Eventually(
+		t,
+		func() bool { return atomic.LoadInt64(&accepted) == 2 },
+		5*time.Second,
+		10*time.Millisecond,
+		"Expected the source pool to be filled",
+	)
+
+	dstClient, err := net.Dial("tcp", dstListenAddress)
+	require.Nil(t, err, "Failed to dial gocat dst address")
+	defer dstClient.Close()
+
+	err = dstClient.SetReadDeadline(time.Now().Add(10 * time.Second))
+	require.Nil(t, err, "Failed to set read deadline")
+
+	// NOTE: Closed instead of relaying the first 4 bytes of the pooled connection's packet.
+	buffer := make([]byte, 1024)
+	n, err := dstClient.Read(buffer)
+	assert.Equal(t, io.EOF, err, "Expected connection with a truncated packet to be closed")
+	assert.Equal(t, 0, n)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&accepted), "Expected the pooled source connection to be used")
+}
+
 func BenchmarkTCPToUnixSequential_Socat(b *testing.B) {
 	err := hasSocatBinary()
 	if err != nil {
@@ -718,6 +3141,7 @@ func prepareGocatTCPToUnixTest(
 	ctx context.Context,
 	t gocatTesting.TestingT,
 	bufferSize int,
+	extraArgs ...string,
 ) *gocatTesting.UnixSocketClient {
 	binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
 
@@ -736,14 +3160,17 @@ func prepareGocatTCPToUnixTest(
 	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with TCP src server")
 
 	go func() {
-		stdout, stderr, err := binaryBuild.Run(
-			ctx,
-			"tcp-to-unix",
-			"--src",
-			testSrcServerListenResult.Address,
-			"--dst",
-			dstListenAddress,
+		args := append(
+			[]string{
+				"tcp-to-unix",
+				"--src",
+				testSrcServerListenResult.Address,
+				"--dst",
+				dstListenAddress,
+			},
+			extraArgs...,
 		)
+		stdout, stderr, err := binaryBuild.Run(ctx, args...)
 		if err != nil {
 			fmt.Printf(
 				"Failed to run TCP to unix command, stdout: %s, stderr: %s, err: %s\n",
@@ -926,6 +3353,7 @@ func prepareGocatUnixToTCPTest(
 	ctx context.Context,
 	t gocatTesting.TestingT,
 	bufferSize int,
+	extraArgs ...string,
 ) *gocatTesting.TCPClient {
 	binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
 
@@ -943,14 +3371,17 @@ func prepareGocatUnixToTCPTest(
 	go func() {
 		err = l.Close()
 		require.Nil(t, err, "Failed to close temporary TCP listener")
-		stdout, stderr, err := binaryBuild.Run(
-			ctx,
-			"unix-to-tcp",
-			"--src",
-			testSrcServerListenResult.Address,
-			"--dst",
-			dstListenAddress,
+		args := append(
+			[]string{
+				"unix-to-tcp",
+				"--src",
+				testSrcServerListenResult.Address,
+				"--dst",
+				dstListenAddress,
+			},
+			extraArgs...,
 		)
+		stdout, stderr, err := binaryBuild.Run(ctx, args...)
 		if err != nil {
 			fmt.Printf(
 				"Failed to run TCP to unix command, stdout: %s, stderr: %s, err: %s\n",
@@ -985,3 +3416,247 @@ func prepareGocatUnixToTCPTest(
 
 	return dstClient
 }
+
+type echoClient interface {
+	SendMsg(msg []byte) (int, error)
+	ReceiveMsg(bufferSize int) ([]byte, error)
+}
+
+func assertEcho(t *testing.T, client echoClient, payload []byte) {
+	n, err := client.SendMsg(payload)
+	require.Nil(t, err, "Failed to send payload to gocat dst address")
+	require.Equal(t, len(payload), n, "Failed to send complete payload to gocat dst address")
+
+	receivedPayload, err := client.ReceiveMsg(len(payload))
+	require.Nil(t, err, "Failed to receive payload from gocat dst address")
+	assert.Equal(t, payload, receivedPayload, "Different sent compared to received payload")
+}
+
+// runGocatUnixToTCPSession relays a single echoed `payload` with gocat unix-to-tcp,
+// returning the gocat output once it's stopped and the unix socket source address.
+func runGocatUnixToTCPSession(
+	ctx context.Context,
+	t *testing.T,
+	payload []byte,
+	extraArgs ...string,
+) (string, string) {
+	ctx, cancelCtx := context.WithCancel(ctx)
+	defer cancelCtx()
+
+	testSrcServer := gocatTesting.NewUnixServer(t, len(payload))
+	srcServerListenCh := make(chan *gocatTesting.ListenResult, 1)
+	go testSrcServer.Serve(srcServerListenCh)
+	testSrcServerListenResult := <-srcServerListenCh
+	require.Nil(t, testSrcServerListenResult.Err, "Failed to listen with Unix socket src server")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Failed to create temporary address")
+	dstListenAddress := l.Addr().String()
+	err = l.Close()
+	require.Nil(t, err, "Failed to close temporary TCP listener")
+
+	args := append(
+		[]string{
+			"unix-to-tcp",
+			"--src",
+			testSrcServerListenResult.Address,
+			"--dst",
+			dstListenAddress,
+		},
+		extraArgs...,
+	)
+
+	outputCh := make(chan string, 1)
+	go func() {
+		binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+		stdout, stderr, _ := binaryBuild.Run(ctx, args...)
+		outputCh <- stdout + stderr
+	}()
+
+	dstClient := waitForTCPClient(ctx, t, dstListenAddress)
+	assertEcho(t, dstClient, payload)
+	dstClient.Close()
+
+	// NOTE: Give gocat time to log the close event before stopping it.
+	time.Sleep(500 * time.Millisecond)
+	cancelCtx()
+
+	return <-outputCh, testSrcServerListenResult.Address
+}
+
+// serveUnixEchoSource echoes back the data of every connection accepted at `path`,
+// returning the listener and the number of connections accepted so far.
+func serveUnixEchoSource(t *testing.T, path string) (net.Listener, func() int64) {
+	listener, err := net.Listen("unix", path)
+	require.Nil(t, err, "Failed to listen with Unix socket src server")
+
+	var accepted int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt64(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener, func() int64 {
+		return atomic.LoadInt64(&accepted)
+	}
+}
+
+// receiveDatagrams returns the datagrams received by `conn` until it's closed or idle for 5 seconds.
+func receiveDatagrams(conn net.PacketConn) <-chan string {
+	datagramsCh := make(chan string, 1024)
+
+	go func() {
+		defer close(datagramsCh)
+
+		buffer := make([]byte, 64*1024)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			datagramsCh <- string(buffer[:n])
+		}
+	}()
+
+	return datagramsCh
+}
+
+func runGocat(ctx context.Context, args ...string) {
+	binaryBuild := testutils.NewBuild(gocatBinaryPath, "")
+
+	go func() {
+		stdout, stderr, err := binaryBuild.Run(ctx, args...)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf(
+				"Failed to run gocat %v, stdout: %s, stderr: %s, err: %s\n",
+				args,
+				stdout,
+				stderr,
+				err,
+			)
+		}
+	}()
+}
+
+// startGocat runs gocat until `ctx` is done and returns its process, to signal it,
+// its stderr and a channel closed once it exited.
+func startGocat(ctx context.Context, t *testing.T, args ...string) (*exec.Cmd, *syncBuffer, <-chan struct{}) {
+	stderr := &syncBuffer{}
+
+	cmd := exec.CommandContext(ctx, gocatBinaryPath, args...)
+	cmd.Env = stdOs.Environ()
+	cmd.Stderr = stderr
+
+	err := cmd.Start()
+	require.Nil(t, err, "Failed to start gocat %v", args)
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	return cmd, stderr, exited
+}
+
+// syncBuffer is a `bytes.Buffer` safe to read while a process writes to it.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
+
+func tempUnixSocketPath(t gocatTesting.TestingT, pattern string) string {
+	fd, err := ioutil.TempFile("", pattern)
+	require.Nil(t, err, "Failed to create temporary file")
+
+	err = stdOs.RemoveAll(fd.Name())
+	require.Nil(t, err, "Failed to delete temporary file")
+
+	return fd.Name()
+}
+
+func waitForUnixClient(
+	ctx context.Context,
+	t gocatTesting.TestingT,
+	address string,
+) *gocatTesting.UnixSocketClient {
+	var dstClient *gocatTesting.UnixSocketClient
+
+	currentRetries := 0
+	clientFn := task.Retry(1*time.Second, func(ctx context.Context) error {
+		currentRetries += 1
+
+		var err error
+		dstClient, err = gocatTesting.NewUnixClient(address)
+		if err != nil {
+			if currentRetries <= 30 {
+				return task.NewRetryableError(err)
+			}
+
+			return err
+		}
+
+		return nil
+	})
+
+	err := clientFn(ctx)
+	require.Nil(t, err)
+
+	return dstClient
+}
+
+func waitForTCPClient(
+	ctx context.Context,
+	t gocatTesting.TestingT,
+	address string,
+) *gocatTesting.TCPClient {
+	var dstClient *gocatTesting.TCPClient
+
+	currentRetries := 0
+	clientFn := task.Retry(1*time.Second, func(ctx context.Context) error {
+		currentRetries += 1
+
+		var err error
+		dstClient, err = gocatTesting.NewTCPClient(address)
+		if err != nil {
+			if currentRetries <= 30 {
+				return task.NewRetryableError(err)
+			}
+
+			return err
+		}
+
+		return nil
+	})
+
+	err := clientFn(ctx)
+	require.Nil(t, err)
+
+	return dstClient
+}